@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fileFlags are the helm and kubectl flags taking files, which may be
+// encrypted.
+var fileFlags = map[string]bool{
+	"--values": true, "-f": true, "--filename": true, "--set-file": true,
+}
+
+// setFileFlags are the flags taking comma separated key=file pairs.
+var setFileFlags = map[string]bool{
+	"--set-file": true,
+}
+
+// kustomizeFlags are the kubectl flags taking a kustomization directory.
+var kustomizeFlags = map[string]bool{
+	"-k": true, "--kustomize": true,
+}
+
+// boolShorthands are the shorthand flags not taking a value, which can be
+// combined with others as in -if values.yaml.
+const boolShorthands = "ghiqRw"
+
+// fileRewriter returns the path to use in place of the given file.
+type fileRewriter func(file string) (string, error)
+
+// rewriteFileArgs calls fn for every file given to a values, filename or
+// set-file flag in args, returning the arguments with the files replaced by
+// the result. All forms accepted by pflag are supported: separate or
+// attached values (--values=f, -ff, -f=f), combined shorthands, repeated
+// flags and comma separated lists.
+func rewriteFileArgs(args []string, fn fileRewriter) ([]string, error) {
+	return rewriteFlagArgs(args, fileFlags, fn)
+}
+
+// rewriteFlagArgs calls fn for every file given to one of flags in args, in
+// the same way as rewriteFileArgs.
+func rewriteFlagArgs(args []string, flags map[string]bool, fn fileRewriter) ([]string, error) {
+	result := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			result = append(result, args[i:]...)
+			break
+		}
+		flag, value, attached := splitFlag(arg)
+		if !flags[flag] {
+			result = append(result, arg)
+			continue
+		}
+		rewrite := rewriteList
+		if setFileFlags[flag] {
+			rewrite = rewriteSetFile
+		} else if kustomizeFlags[flag] {
+			rewrite = rewriteSingle
+		}
+
+		if !attached {
+			result = append(result, arg)
+			if i == len(args)-1 {
+				break
+			}
+			i++
+			value = args[i]
+		}
+		rewritten, err := rewrite(value, fn)
+		if err != nil {
+			return nil, err
+		}
+		if attached {
+			rewritten = strings.TrimSuffix(arg, value) + rewritten
+		}
+		result = append(result, rewritten)
+	}
+	return result, nil
+}
+
+// splitFlag returns the file flag given in arg in its canonical form, and
+// the value attached to it, if any.
+func splitFlag(arg string) (string, string, bool) {
+	if strings.HasPrefix(arg, "--") {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) == 2 {
+			return kv[0], kv[1], true
+		}
+		return arg, "", false
+	}
+	if !strings.HasPrefix(arg, "-") || len(arg) < 2 {
+		return arg, "", false
+	}
+	// skip combined boolean shorthands, the first one taking a value gets
+	// the rest of the argument
+	shorthands := arg[1:]
+	for len(shorthands) > 1 && strings.IndexByte(boolShorthands, shorthands[0]) >= 0 {
+		shorthands = shorthands[1:]
+	}
+	flag := "-" + shorthands[:1]
+	value := strings.TrimPrefix(shorthands[1:], "=")
+	return flag, value, value != ""
+}
+
+// rewriteSingle rewrites a single file.
+func rewriteSingle(value string, fn fileRewriter) (string, error) {
+	return fn(value)
+}
+
+// rewriteList rewrites a comma separated list of files.
+func rewriteList(value string, fn fileRewriter) (string, error) {
+	files := strings.Split(value, ",")
+	for i, f := range files {
+		rewritten, err := fn(f)
+		if err != nil {
+			return "", err
+		}
+		files[i] = rewritten
+	}
+	return strings.Join(files, ","), nil
+}
+
+// rewriteSetFile rewrites the files in a comma separated list of key=file
+// pairs.
+func rewriteSetFile(value string, fn fileRewriter) (string, error) {
+	pairs := strings.Split(value, ",")
+	for i, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		rewritten, err := fn(kv[1])
+		if err != nil {
+			return "", err
+		}
+		pairs[i] = kv[0] + "=" + rewritten
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+// wrapperFlags are the plugin boolean flags accepted by the wrapper commands,
+// which do not parse flags, and not passed on to the wrapped command.
+var wrapperFlags = map[string]*bool{
+	"--pipes":           &Pipes,
+	"--allow-disk-temp": &AllowDiskTemp,
+}
+
+// applyWrapperFlags sets the plugin flags found in args, returning the
+// arguments without them.
+func applyWrapperFlags(args []string) ([]string, error) {
+	result := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(result, args[i:]...), nil
+		}
+		kv := strings.SplitN(arg, "=", 2)
+		flag, ok := wrapperFlags[kv[0]]
+		if !ok {
+			result = append(result, arg)
+			continue
+		}
+		value := true
+		if len(kv) == 2 {
+			var err error
+			if value, err = strconv.ParseBool(kv[1]); err != nil {
+				return nil, fmt.Errorf("invalid value for %v : %v", kv[0], kv[1])
+			}
+		}
+		*flag = value
+	}
+	return result, nil
+}
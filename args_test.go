@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteFileArgs(t *testing.T) {
+	tests := []struct {
+		args     string
+		expected string
+	}{
+		{"install -f s.yaml", "install -f S.YAML"},
+		{"install --values s.yaml --values=v.yaml", "install --values S.YAML --values=V.YAML"},
+		{"install -fs.yaml -f=v.yaml", "install -fS.YAML -f=V.YAML"},
+		{"upgrade -if s.yaml -ifv.yaml", "upgrade -if S.YAML -ifV.YAML"},
+		{"install -f s.yaml,v.yaml --values=a.yaml,b.yaml", "install -f S.YAML,V.YAML --values=A.YAML,B.YAML"},
+		{"apply --filename=s.yaml -Rf dir", "apply --filename=S.YAML -Rf DIR"},
+		{"install --set-file a=s.yaml,b=v.yaml --set-file=c=x.yaml", "install --set-file a=S.YAML,b=V.YAML --set-file=c=X.YAML"},
+		{"install -n f --set f=s.yaml -nf s.yaml", "install -n f --set f=s.yaml -nf s.yaml"},
+		{"install -f", "install -f"},
+		{"install -- -f s.yaml", "install -- -f s.yaml"},
+	}
+	for _, test := range tests {
+		result, err := rewriteFileArgs(strings.Fields(test.args), func(f string) (string, error) {
+			return strings.ToUpper(f), nil
+		})
+		if err != nil {
+			t.Fatalf("failed to rewrite %v :: %v", test.args, err)
+		}
+		if strings.Join(result, " ") != test.expected {
+			t.Errorf("expected: %v :: result: %v", test.expected, strings.Join(result, " "))
+		}
+	}
+}
+
+func TestApplyWrapperFlags(t *testing.T) {
+	defer func() { Pipes, AllowDiskTemp = false, false }()
+	tests := []struct {
+		args     string
+		expected string
+		pipes    bool
+	}{
+		{"install rel chart -f s.yaml", "install rel chart -f s.yaml", false},
+		{"install --pipes rel chart -f s.yaml", "install rel chart -f s.yaml", true},
+		{"install rel chart --pipes=false", "install rel chart", false},
+		{"exec -- cmd --pipes", "exec -- cmd --pipes", false},
+		{"apply --allow-disk-temp -f dir", "apply -f dir", false},
+	}
+	for _, test := range tests {
+		Pipes = false
+		result, err := applyWrapperFlags(strings.Fields(test.args))
+		if err != nil {
+			t.Fatalf("failed to apply flags %v :: %v", test.args, err)
+		}
+		if strings.Join(result, " ") != test.expected || Pipes != test.pipes {
+			t.Errorf("expected: %v %v :: result: %v %v", test.expected, test.pipes, strings.Join(result, " "), Pipes)
+		}
+	}
+	if !AllowDiskTemp {
+		t.Errorf("expected --allow-disk-temp to be set")
+	}
+	if _, err := applyWrapperFlags([]string{"--pipes=maybe"}); err == nil {
+		t.Errorf("expected invalid flag value to fail")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
@@ -11,7 +12,25 @@ import (
 	"github.com/gophercloud/utils/openstack/clientconfig"
 )
 
-func newKeyManager() (*gophercloud.ServiceClient, error) {
+// barbicanProvider stores keys as opaque secrets in OpenStack Barbican.
+type barbicanProvider struct {
+	client *gophercloud.ServiceClient
+}
+
+func newBarbicanProvider(region string) (KeyProvider, error) {
+	client, err := newKeyManager(region)
+	if err != nil {
+		return nil, err
+	}
+	return barbicanProvider{client: client}, nil
+}
+
+// newKeyManager returns a client for Barbican in the given region, or the
+// one set in the environment if empty.
+func newKeyManager(region string) (*gophercloud.ServiceClient, error) {
+	if region == "" {
+		region = os.Getenv("OS_REGION_NAME")
+	}
 	opts, err := clientconfig.AuthOptions(nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to authenticate :: %v : %v", opts, err)
@@ -22,55 +41,102 @@ func newKeyManager() (*gophercloud.ServiceClient, error) {
 	}
 
 	client, err := openstack.NewKeyManagerV1(provider,
-		gophercloud.EndpointOpts{Region: os.Getenv("OS_REGION_NAME")})
+		gophercloud.EndpointOpts{Region: region})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create key manager :: %v", err)
 	}
 	return client, nil
 }
 
-func fetchKey(client *gophercloud.ServiceClient, deployment string) (string, string, error) {
-	// check secret exists, create if not
-	pages, err := secrets.List(client, secrets.ListOpts{Name: deployment}).AllPages()
+func (b barbicanProvider) Fetch(name string) (Key, error) {
+	// check secret exists, create if not, the newest one is used
+	keys, err := b.List(name)
+	if err != nil {
+		return Key{}, err
+	}
+	if len(keys) == 0 {
+		return b.Create(name)
+	}
+	return keys[0], nil
+}
+
+func (b barbicanProvider) Create(name string) (Key, error) {
+	key, nonce, err := newKey()
+	if err != nil {
+		return Key{}, err
+	}
+	createOpts := secrets.CreateOpts{
+		Algorithm:          "aes",
+		BitLength:          256,
+		Mode:               "gcm",
+		Name:               name,
+		Payload:            keyPayload(key, nonce),
+		PayloadContentType: "text/plain",
+		SecretType:         secrets.OpaqueSecret,
+	}
+	secret, err := secrets.Create(b.client, createOpts).Extract()
+	if err != nil {
+		return Key{}, err
+	}
+	secretID, err := parseID(secret.SecretRef)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{ID: secretID, Name: name, Key: key, Nonce: nonce, Created: time.Now().UTC()}, nil
+}
+
+func (b barbicanProvider) Delete(id string) error {
+	return secrets.Delete(b.client, id).ExtractErr()
+}
+
+func (b barbicanProvider) Get(id string) (Key, error) {
+	secret, err := secrets.Get(b.client, id).Extract()
+	if err != nil {
+		return Key{}, err
+	}
+	return b.key(*secret)
+}
+
+func (b barbicanProvider) List(name string) ([]Key, error) {
+	pages, err := secrets.List(b.client, secrets.ListOpts{Name: name}).AllPages()
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 	secs, err := secrets.ExtractSecrets(pages)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	var payload []byte
-	if len(secs) == 0 {
-		key, nonce, err := newKey()
+	keys := []Key{}
+	for _, s := range secs {
+		key, err := b.key(s)
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
-		payload = []byte(fmt.Sprintf("%v\n%v", key, nonce))
-		createOpts := secrets.CreateOpts{
-			Algorithm:          "aes",
-			BitLength:          256,
-			Mode:               "gcm",
-			Name:               deployment,
-			Payload:            string(payload),
-			PayloadContentType: "text/plain",
-			SecretType:         secrets.OpaqueSecret,
-		}
-		secret, err := secrets.Create(client, createOpts).Extract()
-		if err != nil {
-			return "", "", err
-		}
-		secs = []secrets.Secret{*secret}
+		keys = append(keys, key)
 	}
-	secretID, err := parseID(secs[0].SecretRef)
+	sortKeys(keys)
+	return keys, nil
+}
+
+// key retrieves the payload of the given secret.
+func (b barbicanProvider) key(s secrets.Secret) (Key, error) {
+	secretID, err := parseID(s.SecretRef)
 	if err != nil {
-		return "", "", err
+		return Key{}, err
 	}
-	payload, err = secrets.GetPayload(client, secretID, nil).Extract()
+	payload, err := secrets.GetPayload(b.client, secretID, nil).Extract()
 	if err != nil {
-		return "", "", err
+		return Key{}, err
 	}
-	key := strings.Split(string(payload), "\n")
-	return key[0], key[1], nil
+	key, nonce, err := parseKeyPayload(string(payload))
+	if err != nil {
+		return Key{}, fmt.Errorf("secret %v : %v", secretID, err)
+	}
+	return Key{ID: secretID, Name: s.Name, Key: key, Nonce: nonce, Created: s.Created}, nil
+}
+
+func (b barbicanProvider) Rotate(name string) (Key, error) {
+	return b.Create(name)
 }
 
 func parseID(ref string) (string, error) {
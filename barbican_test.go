@@ -16,15 +16,33 @@ func TestFetchKey(t *testing.T) {
 	HandleGetSecretKey(t)
 	HandleGetPayloadKey(t)
 
-	key, nonce, err := fetchKey(client.ServiceClient(), "test")
+	key, err := barbicanProvider{client: client.ServiceClient()}.Fetch("test")
 	if err != nil {
 		t.Fatalf("test failed : %v", err)
 	}
-	if key != "s928HkkJKVCGO1q1aIFq1iWG3ZDh6LB7utsZ1mRqjKg=" {
-		t.Fatalf("got wrong key in payload : %v vs %v", key, GetPayloadResponse)
+	if key.ID != "1b8068c4-3bb6-4be6-8f1e-da0d1ea0b67c" {
+		t.Fatalf("got wrong key id : %v", key.ID)
 	}
-	if nonce != "cWcmxHPcuG0O0hY3" {
-		t.Fatalf("got wrong nonce in payload : %v vs %v", nonce, GetPayloadResponse)
+	if key.Key != "s928HkkJKVCGO1q1aIFq1iWG3ZDh6LB7utsZ1mRqjKg=" {
+		t.Fatalf("got wrong key in payload : %v vs %v", key.Key, GetPayloadResponse)
+	}
+	if key.Nonce != "cWcmxHPcuG0O0hY3" {
+		t.Fatalf("got wrong nonce in payload : %v vs %v", key.Nonce, GetPayloadResponse)
+	}
+}
+
+func TestGetKey(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	HandleGetSecretKey(t)
+	HandleGetPayloadKey(t)
+
+	key, err := barbicanProvider{client: client.ServiceClient()}.Get("1b8068c4-3bb6-4be6-8f1e-da0d1ea0b67c")
+	if err != nil {
+		t.Fatalf("test failed : %v", err)
+	}
+	if key.Name != "test" || key.Key != "s928HkkJKVCGO1q1aIFq1iWG3ZDh6LB7utsZ1mRqjKg=" {
+		t.Fatalf("got wrong key : %+v", key)
 	}
 }
 
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// tempPrefix starts the name of all decrypted temp files and directories,
+// followed by the pid of the process creating them.
+const tempPrefix = "helm-secrets-"
+
+// cleanup holds the decrypted temp files to remove on exit, and the wrapped
+// command receiving the signals while it runs.
+var cleanup = struct {
+	sync.Mutex
+	paths map[string]bool
+	child *os.Process
+}{paths: map[string]bool{}}
+
+// cleanupCmd removes the temp files left behind by crashed runs.
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "remove decrypted files left behind by earlier runs",
+	Long: `This command removes the decrypted files left in shared memory by
+	earlier runs which did not exit cleanly. Files of running processes are
+	kept.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		failed := false
+		for _, dir := range tempDirs() {
+			removed, err := cleanupOrphans(dir)
+			for _, p := range removed {
+				fmt.Println(p)
+			}
+			if err != nil && !os.IsNotExist(err) {
+				log.Errorf("cleanup of %v failed : %v", dir, err)
+				failed = true
+			}
+		}
+		if failed {
+			log.Fatalf("cleanup failed")
+		}
+	},
+}
+
+// tempName returns the name for a new decrypted temp file or directory.
+func tempName(kind string) string {
+	return fmt.Sprintf("%s%d-%s", tempPrefix, os.Getpid(), kind)
+}
+
+// registerTemp registers path to be removed on exit, including fatal errors
+// and signals.
+func registerTemp(path string) {
+	cleanup.Lock()
+	defer cleanup.Unlock()
+	cleanup.paths[path] = true
+}
+
+// removeTemp removes the given registered temp files or directories.
+func removeTemp(paths ...string) {
+	cleanup.Lock()
+	defer cleanup.Unlock()
+	for _, p := range paths {
+		if err := os.RemoveAll(p); err != nil {
+			log.Warnf("failed to remove %v : %v", p, err)
+		}
+		delete(cleanup.paths, p)
+	}
+}
+
+// removeAllTemp removes all registered temp files or directories.
+func removeAllTemp() {
+	cleanup.Lock()
+	paths := make([]string, 0, len(cleanup.paths))
+	for p := range cleanup.paths {
+		paths = append(paths, p)
+	}
+	cleanup.Unlock()
+	removeTemp(paths...)
+}
+
+// setChild sets the wrapped command receiving the signals, nil to handle
+// them in this process.
+func setChild(p *os.Process) {
+	cleanup.Lock()
+	defer cleanup.Unlock()
+	cleanup.child = p
+}
+
+// handleSignals forwards SIGINT and SIGTERM to the wrapped command while one
+// runs, letting it exit first. Otherwise, the temp files are removed and the
+// process exits with 128 plus the signal number.
+func handleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			cleanup.Lock()
+			child := cleanup.child
+			cleanup.Unlock()
+			if child != nil {
+				child.Signal(sig)
+				continue
+			}
+			removeAllTemp()
+			os.Exit(128 + int(sig.(syscall.Signal)))
+		}
+	}()
+}
+
+// cleanupOrphans removes the temp files of the current user in dir created
+// by processes no longer running, returning their paths. Failing to remove
+// an entry does not stop the others from being removed.
+func cleanupOrphans(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	errs := []string{}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), tempPrefix) || !ownedByUser(e) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(e.Name(), tempPrefix), "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(parts[0])
+		if err != nil || processRunning(pid) {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		if err := os.RemoveAll(p); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		removed = append(removed, p)
+	}
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return removed, nil
+}
+
+func init() {
+	log.RegisterExitHandler(removeAllTemp)
+	RootCmd.AddCommand(cleanupCmd)
+}
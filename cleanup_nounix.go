@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+// processRunning checks if a process with the given pid exists. Processes
+// cannot be probed on this platform, so they are assumed to be running and
+// their files are kept.
+func processRunning(pid int) bool {
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanupOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the pid of an exited process, unlikely to be reused right away
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run process :: %v", err)
+	}
+	dead := cmd.Process.Pid
+
+	files := map[string]bool{
+		fmt.Sprintf("%s%d-uuid", tempPrefix, dead):        false,
+		fmt.Sprintf("%s%d-manifests-1", tempPrefix, dead): false,
+		tempName("uuid"):                      true,
+		"unrelated":                           true,
+		fmt.Sprintf("%s%d", tempPrefix, dead): true,
+		filepath.Base(privateTempDir()):       true,
+	}
+	for f := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte("plain"), 0600); err != nil {
+			t.Fatalf("failed to write test data :: %v", err)
+		}
+	}
+
+	removed, err := cleanupOrphans(dir)
+	if err != nil {
+		t.Fatalf("failed to cleanup :: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("expected 2 files removed :: result: %v", removed)
+	}
+	for f, kept := range files {
+		_, err := os.Stat(filepath.Join(dir, f))
+		if (err == nil) != kept {
+			t.Errorf("expected %v kept: %v :: result: %v", f, kept, err == nil)
+		}
+	}
+}
+
+func TestRemoveAllTemp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "plain")
+	ioutil.WriteFile(f, []byte("plain"), 0600)
+	registerTemp(f)
+	removeAllTemp()
+	if _, err := os.Stat(f); !os.IsNotExist(err) {
+		t.Errorf("expected %v to be removed :: result: %v", f, err)
+	}
+}
+
+func TestCleanupOrphansOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of files requires root")
+	}
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run process :: %v", err)
+	}
+	f := filepath.Join(dir, fmt.Sprintf("%s%d-uuid", tempPrefix, cmd.Process.Pid))
+	if err := ioutil.WriteFile(f, []byte("plain"), 0600); err != nil {
+		t.Fatalf("failed to write test data :: %v", err)
+	}
+	if err := os.Lchown(f, 65534, 65534); err != nil {
+		t.Fatalf("failed to change owner :: %v", err)
+	}
+
+	removed, err := cleanupOrphans(dir)
+	if err != nil {
+		t.Fatalf("failed to cleanup :: %v", err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("expected no files removed :: result: %v", removed)
+	}
+	if _, err := os.Stat(f); err != nil {
+		t.Errorf("expected %v kept :: result: %v", f, err)
+	}
+}
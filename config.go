@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigFile is the name of the repository configuration file.
+const ConfigFile = ".helm-secrets.yaml"
+
+// Config holds the repository configuration.
+type Config struct {
+	// EncryptedRegex selects the keys whose values are encrypted.
+	EncryptedRegex string `yaml:"encrypted_regex"`
+	// UnencryptedRegex selects the keys whose values are left in clear text.
+	UnencryptedRegex string `yaml:"unencrypted_regex"`
+	// CreationRules set the key and encryption settings by file path. The
+	// first rule matching a file is used.
+	CreationRules []CreationRule `yaml:"creation_rules"`
+
+	// dir is the directory holding the configuration file.
+	dir string
+}
+
+// CreationRule holds the settings for the files matching its path.
+type CreationRule struct {
+	// Path is a glob relative to the configuration file, where '**' matches
+	// any number of directories. An empty path matches all files.
+	Path string `yaml:"path"`
+	// Release is the name of the key to use.
+	Release string `yaml:"release"`
+	// Provider is the key provider, one of barbican or local.
+	Provider string `yaml:"provider"`
+	// Region is the OpenStack region for the barbican provider.
+	Region string `yaml:"region"`
+	// Mode is the encryption mode, file or values.
+	Mode string `yaml:"mode"`
+	// EncryptedRegex overrides the repository encrypted regex.
+	EncryptedRegex string `yaml:"encrypted_regex"`
+	// UnencryptedRegex overrides the repository unencrypted regex.
+	UnencryptedRegex string `yaml:"unencrypted_regex"`
+	// Bind binds the encrypted content to the release and file.
+	Bind bool `yaml:"bind"`
+}
+
+// findConfig reads the configuration file closest to the given file, walking
+// up its parent directories. No file results in an empty configuration.
+func findConfig(file string) (Config, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return Config{}, err
+	}
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		config, err := loadConfig(filepath.Join(dir, ConfigFile))
+		if err == nil || !os.IsNotExist(err) {
+			return config, err
+		}
+		if dir == filepath.Dir(dir) {
+			return Config{}, nil
+		}
+	}
+}
+
+// loadConfig reads the given configuration file.
+func loadConfig(file string) (Config, error) {
+	config := Config{dir: filepath.Dir(file)}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return config, fmt.Errorf("invalid %v : %v", file, err)
+	}
+	return config, nil
+}
+
+// rule returns the first creation rule matching file, if any.
+func (c Config) rule(file string) (CreationRule, error) {
+	if len(c.CreationRules) == 0 {
+		return CreationRule{}, nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return CreationRule{}, err
+	}
+	rel, err := filepath.Rel(c.dir, abs)
+	if err != nil {
+		return CreationRule{}, err
+	}
+	for _, r := range c.CreationRules {
+		if r.Path == "" {
+			return r, nil
+		}
+		ok, err := matchGlob(r.Path, filepath.ToSlash(rel))
+		if err != nil {
+			return CreationRule{}, fmt.Errorf("invalid rule path %v : %v", r.Path, err)
+		}
+		if ok {
+			return r, nil
+		}
+	}
+	return CreationRule{}, nil
+}
+
+// matchGlob reports whether name matches the slash separated glob pattern,
+// where a '**' element matches any number of path elements.
+func matchGlob(pattern, name string) (bool, error) {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if ok, err := matchElems(pattern[1:], name[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if !ok || err != nil {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+// fileSettings holds the key and encryption settings of a file.
+type fileSettings struct {
+	Release          string
+	Provider         string
+	Region           string
+	Mode             string
+	EncryptedRegex   string
+	UnencryptedRegex string
+	Bind             bool
+}
+
+// wrappedRelease is the release of the wrapped helm command, naming the key
+// when neither the flags nor the matching creation rule do.
+var wrappedRelease string
+
+// resolveSettings returns the settings for file. Flags take precedence over
+// the environment, the matching creation rule and the repository
+// configuration, in this order. Without any of them, the release is the one
+// of the wrapped helm command, or named after the current directory.
+func resolveSettings(file string) (fileSettings, error) {
+	config, err := findConfig(file)
+	if err != nil {
+		return fileSettings{}, err
+	}
+	rule, err := config.rule(file)
+	if err != nil {
+		return fileSettings{}, err
+	}
+	s := fileSettings{
+		Release:          firstOf(Release, rule.Release, wrappedRelease, releaseName()),
+		Provider:         firstOf(Provider, os.Getenv(ProviderEnv), rule.Provider),
+		Region:           rule.Region,
+		Mode:             firstOf(Mode, rule.Mode),
+		EncryptedRegex:   EncryptedRegex,
+		UnencryptedRegex: UnencryptedRegex,
+		Bind:             Bind || rule.Bind,
+	}
+	if s.EncryptedRegex == "" && s.UnencryptedRegex == "" {
+		s.EncryptedRegex, s.UnencryptedRegex = rule.EncryptedRegex, rule.UnencryptedRegex
+	}
+	if s.EncryptedRegex == "" && s.UnencryptedRegex == "" {
+		s.EncryptedRegex, s.UnencryptedRegex = config.EncryptedRegex, config.UnencryptedRegex
+	}
+	return s, nil
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// keyrings caches the keyrings opened by fileKeyring.
+var keyrings = map[fileSettings]*keyring{}
+
+// fileKeyring returns the keyring holding the keys of file, according to its
+// settings.
+func fileKeyring(file string) (*keyring, error) {
+	s, err := resolveSettings(file)
+	if err != nil {
+		return nil, err
+	}
+	id := fileSettings{Release: s.Release, Provider: s.Provider, Region: s.Region}
+	if k, ok := keyrings[id]; ok {
+		return k, nil
+	}
+	k, err := newKeyring(s.Release, s.Provider, s.Region)
+	if err != nil {
+		return nil, err
+	}
+	keyrings[id] = k
+	return k, nil
+}
+
+// encryptOptions control how content is encrypted.
+type encryptOptions struct {
+	// Mode is one of FileMode or ValuesMode.
+	Mode string
+	// Bind binds the content to the release and file.
+	Bind bool
+	// Include selects the keys to encrypt in values mode, all if nil.
+	Include *regexp.Regexp
+	// Exclude selects the keys to leave in clear text in values mode.
+	Exclude *regexp.Regexp
+}
+
+// encrypted checks if the value found under the given keys is to be
+// encrypted. A key matching the rules applies to all values below it.
+func (o encryptOptions) encrypted(keys []string) bool {
+	if o.Exclude != nil {
+		for _, k := range keys {
+			if o.Exclude.MatchString(k) {
+				return false
+			}
+		}
+	}
+	if o.Include == nil {
+		return true
+	}
+	for _, k := range keys {
+		if o.Include.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptionOptions resolves the options to encrypt file from its settings.
+// The mode and binding of its existing encrypted content are kept unless
+// requested otherwise.
+func encryptionOptions(file string, existing []byte) (encryptOptions, error) {
+	s, err := resolveSettings(file)
+	if err != nil {
+		return encryptOptions{}, err
+	}
+	opts := encryptOptions{Mode: s.Mode, Bind: s.Bind || isBound(existing)}
+	if s.EncryptedRegex != "" {
+		if opts.Include, err = regexp.Compile(s.EncryptedRegex); err != nil {
+			return opts, fmt.Errorf("invalid encrypted regex : %v", err)
+		}
+	}
+	if s.UnencryptedRegex != "" {
+		if opts.Exclude, err = regexp.Compile(s.UnencryptedRegex); err != nil {
+			return opts, fmt.Errorf("invalid unencrypted regex : %v", err)
+		}
+	}
+
+	if opts.Mode == "" {
+		opts.Mode = contentMode(existing)
+	}
+	if opts.Mode == "" && (opts.Include != nil || opts.Exclude != nil) {
+		opts.Mode = ValuesMode
+	}
+	if opts.Mode == "" {
+		opts.Mode = FileMode
+	}
+	return opts, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*.yaml", "secrets.yaml", true},
+		{"*.yaml", "prod/secrets.yaml", false},
+		{"prod/*.yaml", "prod/secrets.yaml", true},
+		{"**/secrets.yaml", "secrets.yaml", true},
+		{"**/secrets.yaml", "env/prod/secrets.yaml", true},
+		{"env/**/*.yaml", "env/prod/eu/values.yaml", true},
+		{"env/**", "other/values.yaml", false},
+	}
+	for _, test := range tests {
+		match, err := matchGlob(test.pattern, test.name)
+		if err != nil {
+			t.Fatalf("failed to match %v :: %v", test.pattern, err)
+		}
+		if match != test.match {
+			t.Errorf("expected %v for %v on %v", test.match, test.pattern, test.name)
+		}
+	}
+}
+
+const testConfig = `
+encrypted_regex: password
+creation_rules:
+  - path: prod/**
+    release: mariadb-prod
+    region: cern
+    mode: values
+    unencrypted_regex: ^user$
+  - path: "*.yaml"
+    release: mariadb
+    provider: local
+`
+
+func TestResolveSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, ConfigFile), []byte(testConfig), 0644); err != nil {
+		t.Fatalf("failed to write config :: %v", err)
+	}
+
+	tests := []struct {
+		file     string
+		expected fileSettings
+	}{
+		{"prod/eu/secrets.yaml", fileSettings{Release: "mariadb-prod", Region: "cern", Mode: ValuesMode, UnencryptedRegex: "^user$"}},
+		{"secrets.yaml", fileSettings{Release: "mariadb", Provider: "local", EncryptedRegex: "password"}},
+		{"other/secrets.yaml", fileSettings{Release: releaseName(), EncryptedRegex: "password"}},
+	}
+	for _, test := range tests {
+		s, err := resolveSettings(filepath.Join(dir, test.file))
+		if err != nil {
+			t.Fatalf("failed to resolve settings for %v :: %v", test.file, err)
+		}
+		if s != test.expected {
+			t.Errorf("expected: %+v :: result: %+v", test.expected, s)
+		}
+	}
+
+	// flags take precedence
+	Release, Mode = "flag", FileMode
+	defer func() { Release, Mode = "", "" }()
+	s, _ := resolveSettings(filepath.Join(dir, "prod", "secrets.yaml"))
+	if s.Release != "flag" || s.Mode != FileMode || s.Region != "cern" {
+		t.Errorf("unexpected settings :: %+v", s)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, ConfigFile), []byte("unknown: 1\n"), 0644)
+	if _, err := resolveSettings(filepath.Join(dir, "secrets.yaml")); err == nil {
+		t.Errorf("expected invalid config to fail")
+	}
+}
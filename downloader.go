@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// DownloaderScheme is the url scheme handled by the helm downloader.
+const DownloaderScheme = "secrets://"
+
+// downloaderCmd is the helm downloader plugin entrypoint.
+var downloaderCmd = &cobra.Command{
+	Use:   "downloader CERT KEY CA URL",
+	Short: "helm downloader for secrets:// urls, decrypting to stdout",
+	Long: `This command is run by helm for secrets:// urls, as in
+	helm install -f secrets://secrets.yaml. The file is decrypted to stdout,
+	the certificate arguments given by helm being ignored.`,
+	Args: cobra.ExactArgs(4),
+	Run: func(cmd *cobra.Command, args []string) {
+		// stdout holds the values read by helm, which only shows stderr
+		log.SetOutput(os.Stderr)
+		if err := downloadSecret(args[3], os.Stdout); err != nil {
+			log.Fatalf("download failed : %v", err)
+		}
+	},
+}
+
+// downloadSecret writes the decrypted content of the file in url to w.
+// Files not encrypted are written as they are.
+func downloadSecret(url string, w io.Writer) error {
+	if !strings.HasPrefix(url, DownloaderScheme) {
+		return fmt.Errorf("unsupported url %v, expected %v", url, DownloaderScheme)
+	}
+	file := strings.TrimPrefix(url, DownloaderScheme)
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if isEncrypted(content) {
+		keys, err := fileKeyring(file)
+		if err != nil {
+			return err
+		}
+		if content, err = keys.decryptContent(content, file); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+func init() {
+	RootCmd.AddCommand(downloaderCmd)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadSecret(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "secrets.yaml")
+	content := "password: hunter2\n"
+	ioutil.WriteFile(f, []byte(content), 0600)
+	if err := encryptFile(f); err != nil {
+		t.Fatalf("failed to encrypt file :: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := downloadSecret("secrets://"+f, &out); err != nil {
+		t.Fatalf("failed to download :: %v", err)
+	}
+	if out.String() != content {
+		t.Errorf("expected: %v :: result: %v", content, out.String())
+	}
+	if err := downloadSecret("https://example.com/secrets.yaml", &out); err == nil {
+		t.Errorf("expected unsupported url to fail")
+	}
+}
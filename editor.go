@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/google/uuid"
@@ -16,30 +17,88 @@ const (
 	DefaultEditor = "vim"
 )
 
+// Editor is the command editing files, given as the last argument.
 type Editor struct {
 	Binary string
+	Args   []string
 }
 
+// NewEditor returns the editor set in $VISUAL or $EDITOR, which may hold
+// arguments quoted as in a shell, or the default editor.
 func NewEditor() (Editor, error) {
-	bin := os.Getenv("EDITOR")
-	if bin == "" {
-		bin = DefaultEditor
-	} else {
-		elems := strings.Fields(bin)
-		if len(elems) > 0 {
-			bin = elems[0]
-		}
+	line := os.Getenv("VISUAL")
+	if line == "" {
+		line = os.Getenv("EDITOR")
+	}
+	words, err := splitWords(line)
+	if err != nil {
+		return Editor{}, fmt.Errorf("invalid editor %q : %v", line, err)
+	}
+	if len(words) == 0 {
+		words = []string{DefaultEditor}
 	}
 
+	bin := words[0]
 	path, err := exec.LookPath(bin)
 	if err != nil {
-		return Editor{Binary: bin}, err
+		return Editor{Binary: bin, Args: words[1:]}, err
+	}
+	return Editor{Binary: path, Args: words[1:]}, nil
+}
+
+// splitWords splits line into words as a shell does, handling single and
+// double quotes and backslash escapes. Expansions are not supported.
+func splitWords(line string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
 	}
-	return Editor{Binary: path}, nil
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
 }
 
 func (e Editor) Launch(path string) error {
-	cmd := exec.Command(e.Binary, path)
+	cmd := exec.Command(e.Binary, append(e.Args[:len(e.Args):len(e.Args)], path)...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
@@ -59,12 +118,19 @@ func (e Editor) LaunchTemp(r io.Reader) ([]byte, string, error) {
 	if err != nil {
 		return []byte{}, "", err
 	}
-	tmpf := fmt.Sprintf("/dev/shm/%v", uuid)
-	f, err := os.OpenFile(tmpf, os.O_RDWR|os.O_CREATE, 0600)
+	dir, err := secureTempDir()
+	if err != nil {
+		return nil, "", err
+	}
+	// the suffix lets editors enable yaml syntax highlighting
+	tmpf := filepath.Join(dir, tempName(uuid.String()+".yaml"))
+	registerTemp(tmpf)
+	f, err := os.OpenFile(tmpf, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
 	if err != nil {
 		return nil, "", err
 	}
-	defer os.Remove(f.Name())
+	defer removeTemp(f.Name())
+	defer f.Close()
 	if _, err := io.Copy(f, r); err != nil {
 		return nil, "", err
 	}
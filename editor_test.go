@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"", []string{}},
+		{"vim", []string{"vim"}},
+		{"code --wait", []string{"code", "--wait"}},
+		{"  emacsclient   -t ", []string{"emacsclient", "-t"}},
+		{`"/opt/my editor/bin/ed" -w`, []string{"/opt/my editor/bin/ed", "-w"}},
+		{`vim -c 'set ft=yaml'`, []string{"vim", "-c", "set ft=yaml"}},
+		{`my\ editor ''`, []string{"my editor", ""}},
+		{`ed "a \"b\""`, []string{"ed", `a "b"`}},
+	}
+	for _, test := range tests {
+		words, err := splitWords(test.line)
+		if err != nil {
+			t.Fatalf("failed to split %q :: %v", test.line, err)
+		}
+		if !reflect.DeepEqual(words, test.expected) {
+			t.Errorf("expected: %q :: result: %q", test.expected, words)
+		}
+	}
+	for _, line := range []string{`vim 'a`, `vim "a`, `vim \`} {
+		if _, err := splitWords(line); err == nil {
+			t.Errorf("expected %q to fail", line)
+		}
+	}
+}
+
+func TestNewEditor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the editor records its arguments, checking the file suffix
+	editor := filepath.Join(dir, "my editor")
+	args := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > '" + args + "'\ncase \"$3\" in *.yaml) ;; *) exit 1 ;; esac\n"
+	ioutil.WriteFile(editor, []byte(script), 0700)
+
+	origVisual, origEditor := os.Getenv("VISUAL"), os.Getenv("EDITOR")
+	defer func() {
+		os.Setenv("VISUAL", origVisual)
+		os.Setenv("EDITOR", origEditor)
+	}()
+	os.Setenv("EDITOR", "vi")
+	os.Setenv("VISUAL", "'"+editor+"' --wait -t")
+
+	ed, err := NewEditor()
+	if err != nil {
+		t.Fatalf("failed to get editor :: %v", err)
+	}
+	if ed.Binary != editor || !reflect.DeepEqual(ed.Args, []string{"--wait", "-t"}) {
+		t.Errorf("expected: %v [--wait -t] :: result: %v %v", editor, ed.Binary, ed.Args)
+	}
+	if _, _, err := ed.LaunchTemp(strings.NewReader("a: 1\n")); err != nil {
+		t.Fatalf("failed to launch editor :: %v", err)
+	}
+	result, _ := ioutil.ReadFile(args)
+	if !strings.HasPrefix(string(result), "--wait -t ") {
+		t.Errorf("expected editor arguments :: result: %v", string(result))
+	}
+}
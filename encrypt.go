@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -27,30 +28,9 @@ var encryptCmd = &cobra.Command{
 	'view' and 'edit' being preferred.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		secretsFile := args[0]
-		content, err := ioutil.ReadFile(secretsFile)
-		if err != nil {
+		if err := encryptFile(args[0]); err != nil {
 			log.Fatalf("encrypt failed : %v", err)
 		}
-		if b64Encoded(string(content)) {
-			log.Fatal("content is empty or already encrypted")
-		}
-
-		client, err := newKeyManager()
-		if err != nil {
-			log.Fatalf("could not init client :: %v", err)
-		}
-		key, nonce, err := fetchKey(client, releaseName())
-		if err != nil {
-			log.Fatalf("could not fetch key : %v", err)
-		}
-
-		result, err := encrypt(key, nonce, content)
-		err = ioutil.WriteFile(secretsFile, result, 0644)
-		if err != nil {
-			log.Fatalf("encrypt failed : %v", err)
-		}
-
 	},
 }
 
@@ -63,30 +43,9 @@ var decryptCmd = &cobra.Command{
 	and 'edit' being preferred.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		secretsFile := args[0]
-		content, err := ioutil.ReadFile(secretsFile)
-		if err != nil {
+		if err := decryptFile(args[0]); err != nil {
 			log.Fatalf("decrypt failed : %v", err)
 		}
-		if !b64Encoded(string(content)) {
-			log.Fatal("not touching unencrypted content")
-		}
-		client, err := newKeyManager()
-		if err != nil {
-			log.Fatalf("could not init client :: %v", err)
-		}
-		key, nonce, err := fetchKey(client, releaseName())
-		if err != nil {
-			log.Fatalf("could not get key : %v", err)
-		}
-		plain, err := decrypt(key, nonce, string(content))
-		if err != nil {
-			log.Fatalf("decrypt failed : %v", err)
-		}
-		err = ioutil.WriteFile(secretsFile, plain, 0644)
-		if err != nil {
-			log.Fatalf("could not write file : %v", err)
-		}
 	},
 }
 
@@ -98,26 +57,9 @@ var viewCmd = &cobra.Command{
 	and displays them in stdout. The contents are never stored unencrypted.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		secretsFile := args[0]
-		content, err := ioutil.ReadFile(secretsFile)
-		if err != nil {
+		if err := viewFile(args[0], os.Stdout); err != nil {
 			log.Fatalf("decrypt failed : %v", err)
 		}
-		if b64Encoded(string(content)) {
-			client, err := newKeyManager()
-			if err != nil {
-				log.Fatalf("could not init client :: %v", err)
-			}
-			key, nonce, err := fetchKey(client, releaseName())
-			if err != nil {
-				log.Fatalf("could not get key :: %v", err)
-			}
-			content, err = decrypt(key, nonce, string(content))
-			if err != nil {
-				log.Fatalf("decrypt failed : %v", err)
-			}
-		}
-		fmt.Printf("%v", string(content))
 	},
 }
 
@@ -127,55 +69,171 @@ var editCmd = &cobra.Command{
 	Short: "edit secrets",
 	Long: `This command launches the system configured editor with the
 	contents of a given secrets yaml file. The contents are decrypted for
-	editing and encrypted on exit.`,
+	editing and encrypted on exit. Yaml files are validated, against the
+	--schema if given (chart for the values.schema.json of their chart), and
+	the editor is reopened on errors.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		secretsFile := args[0]
-		client, err := newKeyManager()
-		if err != nil {
-			log.Fatalf("could not init client :: %v", err)
+		if err := editFile(args[0]); err != nil {
+			log.Fatalf("edit failed : %v", err)
 		}
-		key, nonce, err := fetchKey(client, releaseName())
+	},
+}
+
+// encryptFile encrypts the given file in place with its release key.
+func encryptFile(secretsFile string) error {
+	content, err := ioutil.ReadFile(secretsFile)
+	if err != nil {
+		return err
+	}
+	if b64Encoded(string(content)) || isEncrypted(content) {
+		return fmt.Errorf("content is empty or already encrypted")
+	}
+	opts, err := encryptionOptions(secretsFile, nil)
+	if err != nil {
+		return err
+	}
+	keys, err := fileKeyring(secretsFile)
+	if err != nil {
+		return err
+	}
+	result, err := keys.encryptContent(content, secretsFile, opts)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(secretsFile, result, 0644)
+}
+
+// decryptFile decrypts the given file in place with its release key.
+func decryptFile(secretsFile string) error {
+	content, err := ioutil.ReadFile(secretsFile)
+	if err != nil {
+		return err
+	}
+	if !isEncrypted(content) {
+		return fmt.Errorf("not touching unencrypted content")
+	}
+	keys, err := fileKeyring(secretsFile)
+	if err != nil {
+		return err
+	}
+	plain, err := keys.decryptContent(content, secretsFile)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(secretsFile, plain, 0644); err != nil {
+		return fmt.Errorf("could not write file : %v", err)
+	}
+	return nil
+}
+
+// viewFile writes the decrypted contents of the given file to w.
+func viewFile(secretsFile string, w io.Writer) error {
+	content, err := ioutil.ReadFile(secretsFile)
+	if err != nil {
+		return err
+	}
+	if isEncrypted(content) {
+		keys, err := fileKeyring(secretsFile)
 		if err != nil {
-			log.Fatalf("could not fetch key : %v", err)
-		}
-		content, err := ioutil.ReadFile(secretsFile)
-		if err != nil && !os.IsNotExist(err) {
-			log.Fatalf("decrypt failed : %v", err)
-		}
-		if b64Encoded(string(content)) {
-			content, err = decrypt(key, nonce, string(content))
-			if err != nil {
-				log.Fatalf("decrypt failed : %v", err)
-			}
+			return err
 		}
-		ed, err := NewEditor()
+		content, err = keys.decryptContent(content, secretsFile)
 		if err != nil {
-			log.Fatalf("failed to find editor %v", err)
+			return err
 		}
-		result, _, err := ed.LaunchTemp(strings.NewReader(string(content)))
+	}
+	_, err = fmt.Fprintf(w, "%v", string(content))
+	return err
+}
+
+// editFile opens the decrypted contents of the given file in an editor,
+// and stores the result encrypted. The file is created if it does not exist.
+func editFile(secretsFile string) error {
+	keys, err := fileKeyring(secretsFile)
+	if err != nil {
+		return err
+	}
+	existing, err := ioutil.ReadFile(secretsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	opts, err := encryptionOptions(secretsFile, existing)
+	if err != nil {
+		return err
+	}
+	plain := existing
+	if isEncrypted(existing) {
+		plain, err = keys.decryptContent(existing, secretsFile)
 		if err != nil {
-			log.Fatalf("failed to open tmp file : %v", err)
+			return fmt.Errorf("decrypt failed : %v", err)
 		}
-		encrypted, err := encrypt(key, nonce, result)
+	}
+	ed, err := NewEditor()
+	if err != nil {
+		return fmt.Errorf("failed to find editor %v", err)
+	}
+	content := plain
+	var result []byte
+	for {
+		result, _, err = ed.LaunchTemp(strings.NewReader(string(content)))
 		if err != nil {
-			log.Fatalf("failed to encrypt contents : %v", err)
+			return fmt.Errorf("failed to open tmp file : %v", err)
 		}
-		err = ioutil.WriteFile(secretsFile, encrypted, 0600)
+		result = stripErrorHeader(result)
+		verr := validateEdit(secretsFile, opts, result)
+		if verr == nil {
+			break
+		}
+		if confirm(fmt.Sprintf("%v\nReopen the editor? [Y/n] ", verr)) {
+			content = append(errorHeader(verr), result...)
+			continue
+		}
+		// valid yaml only failing the schema is saved anyway
+		if validateValues(result, "") == nil {
+			log.Warnf("saving %v not matching its schema : %v", secretsFile, verr)
+			break
+		}
+		// keep the invalid edits encrypted instead of discarding them
+		rejected := secretsFile + RejectedSuffix
+		encrypted, err := keys.encryptContent(result, secretsFile, encryptOptions{Mode: FileMode, Bind: opts.Bind})
 		if err != nil {
-			log.Fatalf("failed to encrypt : %v", err)
+			return fmt.Errorf("%v left unchanged, failed to keep the edits : %v", secretsFile, err)
 		}
-	},
+		if err := ioutil.WriteFile(rejected, encrypted, 0600); err != nil {
+			return fmt.Errorf("%v left unchanged, failed to keep the edits : %v", secretsFile, err)
+		}
+		return fmt.Errorf("%v left unchanged, edits kept encrypted in %v : %v", secretsFile, rejected, verr)
+	}
+	// leave the file untouched unless its content or encryption changes,
+	// including the values selected by the rules in values mode
+	unchanged := bytes.Equal(result, plain) && (existing == nil ||
+		(isEncrypted(existing) && !isLegacy(existing) && contentMode(existing) == opts.Mode &&
+			isBound(existing) == opts.Bind))
+	if unchanged && existing != nil && opts.Mode == ValuesMode {
+		if unchanged, err = sameSelection(existing, plain, opts); err != nil {
+			return fmt.Errorf("failed to check encrypted values : %v", err)
+		}
+	}
+	if unchanged {
+		fmt.Printf("%v : no changes\n", secretsFile)
+		return nil
+	}
+	encrypted, err := keys.encryptContent(result, secretsFile, opts)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt contents : %v", err)
+	}
+	return ioutil.WriteFile(secretsFile, encrypted, 0600)
 }
 
 func newKey() (string, string, error) {
-	key := make([]byte, 32)
+	key := make([]byte, keySize)
 	_, err := rand.Read(key)
 	if err != nil {
 		return "", "", err
 	}
 
-	nonce := make([]byte, 12)
+	nonce := make([]byte, nonceSize)
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", "", err
 	}
@@ -183,58 +241,123 @@ func newKey() (string, string, error) {
 	return base64.StdEncoding.EncodeToString(key), base64.StdEncoding.EncodeToString(nonce), nil
 }
 
-func encrypt(b64key string, b64nonce string, payload []byte) ([]byte, error) {
-	key, err := base64.StdEncoding.DecodeString(b64key)
-	if err != nil {
-		return nil, err
+// encrypt seals payload with AES-GCM under a fresh random nonce, and returns
+// the base64 encoded envelope recording the id of the key used. The optional
+// aad is authenticated and recorded in the envelope, but not encrypted.
+func encrypt(key Key, payload []byte, aad []byte) ([]byte, error) {
+	if len(key.ID) > 255 {
+		return nil, fmt.Errorf("key id too long : %v", key.ID)
 	}
-	nonce, err := base64.StdEncoding.DecodeString(b64nonce)
-	if err != nil {
-		return nil, err
+	if len(aad) > 65535 {
+		return nil, fmt.Errorf("additional data too long")
 	}
-	block, err := aes.NewCipher(key)
+	aesgcm, err := newGCM(key.Key)
 	if err != nil {
 		return nil, err
 	}
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
-	sealed := aesgcm.Seal(nil, nonce, payload, nil)
+	e := envelope{
+		Version:    envelopeV3,
+		KeyID:      key.ID,
+		AAD:        aad,
+		Nonce:      nonce,
+		Ciphertext: aesgcm.Seal(nil, nonce, payload, aad),
+	}
+	sealed := e.marshal()
 	result := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
 	base64.StdEncoding.Encode(result, sealed)
 	return result, nil
 }
 
-func decrypt(b64key string, b64nonce string, b64payload string) ([]byte, error) {
+// decrypt opens a base64 encoded payload. Envelope payloads carry their own
+// nonce, while legacy payloads are opened with the nonce stored with the key.
+func decrypt(key Key, b64payload string) ([]byte, error) {
 	if b64payload == "" {
 		return []byte{}, nil
 	}
-	key, err := base64.StdEncoding.DecodeString(b64key)
+	payload, err := base64.StdEncoding.DecodeString(b64payload)
 	if err != nil {
 		return nil, err
 	}
-	nonce, err := base64.StdEncoding.DecodeString(b64nonce)
+	aesgcm, err := newGCM(key.Key)
 	if err != nil {
 		return nil, err
 	}
-	payload, err := base64.StdEncoding.DecodeString(b64payload)
-	if err != nil {
-		return nil, err
+	if isEnvelope(payload) {
+		e, err := parseEnvelope(payload)
+		if err != nil {
+			return nil, err
+		}
+		return aesgcm.Open(nil, e.Nonce, e.Ciphertext, e.AAD)
 	}
-	block, err := aes.NewCipher(key)
+	nonce, err := base64.StdEncoding.DecodeString(key.Nonce)
 	if err != nil {
 		return nil, err
 	}
-	aesgcm, err := cipher.NewGCM(block)
+	return aesgcm.Open(nil, nonce, payload, nil)
+}
+
+func newGCM(b64key string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(b64key)
 	if err != nil {
 		return nil, err
 	}
-	plain, err := aesgcm.Open(nil, nonce, payload, nil)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	return plain, nil
+	return cipher.NewGCM(block)
+}
+
+// contentMode returns the mode content is encrypted with, or an empty string
+// if it is not encrypted.
+func contentMode(content []byte) string {
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return ""
+	}
+	if b64Encoded(string(content)) {
+		return FileMode
+	}
+	if hasEncryptedValues(content) {
+		return ValuesMode
+	}
+	return ""
+}
+
+// isEncrypted checks if content holds an encrypted payload, in any mode.
+func isEncrypted(content []byte) bool {
+	return contentMode(content) != ""
+}
+
+// isBound checks if encrypted content is bound to its release and file.
+func isBound(content []byte) bool {
+	payload := string(content)
+	if contentMode(content) == ValuesMode {
+		payload = encValue.FindStringSubmatch(string(encValueSearch.Find(content)))[1]
+	}
+	return envelopeBinding(payload) != binding{}
+}
+
+// isLegacy checks if encrypted content holds payloads written by older
+// versions, not in the envelope format.
+func isLegacy(content []byte) bool {
+	payloads := []string{string(content)}
+	if contentMode(content) == ValuesMode {
+		payloads = []string{}
+		for _, v := range encValueSearch.FindAllString(string(content), -1) {
+			payloads = append(payloads, encValue.FindStringSubmatch(v)[1])
+		}
+	}
+	for _, p := range payloads {
+		data, err := base64.StdEncoding.DecodeString(p)
+		if err != nil || !isEnvelope(data) {
+			return true
+		}
+	}
+	return false
 }
 
 func b64Encoded(content string) bool {
@@ -258,4 +381,6 @@ func init() {
 	RootCmd.AddCommand(decryptCmd)
 	RootCmd.AddCommand(viewCmd)
 	RootCmd.AddCommand(editCmd)
+
+	editCmd.Flags().StringVarP(&Schema, "schema", "", "", "JSON schema validating the edited values, chart for the chart values.schema.json")
 }
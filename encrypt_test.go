@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -13,8 +12,6 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-var update = flag.Bool("update", false, "update golden test data")
-
 func TestEncrypt(t *testing.T) {
 
 	tests, err := filepath.Glob("testdata/encrypt_*.yaml")
@@ -23,46 +20,71 @@ func TestEncrypt(t *testing.T) {
 	}
 	for _, test := range tests {
 		keyfile := fmt.Sprintf("%v.key", test)
-		encrypted := fmt.Sprintf("%v.enc", test)
 
 		content, err := ioutil.ReadFile(test)
 		if err != nil {
 			t.Fatalf("failed to read file %v :: %v", test, err)
 		}
-
-		// update golden data for test if flag given
-		if *update {
-			key, nonce, err := newKey()
-			fmt.Printf("%v\n%v\n\n", key, nonce)
-			ioutil.WriteFile(keyfile, []byte(fmt.Sprintf("%v\n%v", key, nonce)), 0644)
-			result, err := encrypt(key, nonce, content)
-			if err != nil {
-				t.Fatalf("failed to encrypt data %v :: %v", test, err)
-			}
-			ioutil.WriteFile(encrypted, result, 0644)
-		}
-
 		keycontent, err := ioutil.ReadFile(keyfile)
 		if err != nil {
 			t.Fatalf("failed to read key %v :: %v", test, err)
 		}
-		key := strings.Split(string(keycontent), "\n")
-		result, err := encrypt(string(key[0]), string(key[1]), content)
+		parts := strings.Split(string(keycontent), "\n")
+		key := Key{ID: "test", Key: parts[0]}
+
+		first, err := encrypt(key, content, nil)
 		if err != nil {
 			t.Fatalf("failed to encrypt data %v :: %v", test, err)
 		}
+		second, err := encrypt(key, content, nil)
+		if err != nil {
+			t.Fatalf("failed to encrypt data %v :: %v", test, err)
+		}
+		if bytes.Equal(first, second) {
+			t.Errorf("nonce reused for %v :: %v", test, string(first))
+		}
+
+		if id := envelopeKeyID(string(first)); id != key.ID {
+			t.Errorf("expected key id %v :: result: %v", key.ID, id)
+		}
 
-		expected, err := ioutil.ReadFile(encrypted)
+		// the stored nonce is ignored for envelope payloads
+		result, err := decrypt(key, string(first))
 		if err != nil {
-			t.Fatalf("failed to read encrypted data %v :: %v", test, err)
+			t.Fatalf("failed to decrypt data %v :: %v", test, err)
 		}
-		if bytes.Compare(result, expected) != 0 {
-			t.Errorf("expected: %v :: result: %v", expected, result)
+		if bytes.Compare(result, content) != 0 {
+			t.Errorf("expected: %v :: result: %v", content, result)
 		}
 	}
 
 }
 
+func TestParseEnvelope(t *testing.T) {
+	nonce := bytes.Repeat([]byte{1}, nonceSize)
+	for _, e := range []envelope{
+		{Version: envelopeV1, Nonce: nonce, Ciphertext: []byte("sealed")},
+		{Version: envelopeV2, KeyID: "1b8068c4", Nonce: nonce, Ciphertext: []byte("sealed")},
+		{Version: envelopeV3, KeyID: "1b8068c4", AAD: []byte("release=test"), Nonce: nonce, Ciphertext: []byte("sealed")},
+	} {
+		parsed, err := parseEnvelope(e.marshal())
+		if err != nil {
+			t.Fatalf("failed to parse envelope :: %v", err)
+		}
+		if parsed.Version != e.Version || parsed.KeyID != e.KeyID || !bytes.Equal(parsed.AAD, e.AAD) ||
+			!bytes.Equal(parsed.Nonce, nonce) || string(parsed.Ciphertext) != "sealed" {
+			t.Errorf("expected: %+v :: result: %+v", e, parsed)
+		}
+	}
+
+	for _, data := range []string{"", envelopeMagic, envelopeMagic + "\x01short", envelopeMagic + "\x02\x09id",
+		envelopeMagic + "\x03\x00\x00\x09", envelopeMagic + "\x09"} {
+		if _, err := parseEnvelope([]byte(data)); err == nil {
+			t.Errorf("expected failure parsing %q", data)
+		}
+	}
+}
+
 func TestDecrypt(t *testing.T) {
 
 	tests, err := filepath.Glob("testdata/encrypt_*.yaml")
@@ -82,7 +104,7 @@ func TestDecrypt(t *testing.T) {
 			t.Fatalf("failed to read key %v :: %v", test, err)
 		}
 		key := strings.Split(string(keycontent), "\n")
-		result, err := decrypt(string(key[0]), string(key[1]), string(content))
+		result, err := decrypt(Key{Key: key[0], Nonce: key[1]}, string(content))
 		if err != nil {
 			t.Fatalf("failed to encrypt data %v :: %v", test, err)
 		}
@@ -115,28 +137,33 @@ func Example_encrypt() {
 		  other: 2
 	`
 
-	// Sample AES-256 GCM key and nonce, use output from newKey()
-	key := "s928HkkJKVCGO1q1aIFq1iWG3ZDh6LB7utsZ1mRqjKg="
-	nonce := "cWcmxHPcuG0O0hY3"
+	// Sample AES-256 GCM key, use output from newKey()
+	key := Key{ID: "example", Key: "s928HkkJKVCGO1q1aIFq1iWG3ZDh6LB7utsZ1mRqjKg="}
 
-	result, err := encrypt(key, nonce, []byte(content))
+	// A new random nonce is used for every call, so the result differs
+	// each time even for the same content
+	result, err := encrypt(key, []byte(content), nil)
 	if err != nil {
 		log.Fatalf("encrypt failed : %v", err)
 	}
-	fmt.Printf("%v", string(result))
+	plain, err := decrypt(key, string(result))
+	if err != nil {
+		log.Fatalf("decrypt failed : %v", err)
+	}
+	fmt.Printf("%v", string(plain) == content)
 	// Output:
-	// EetswXTplHOz9LXemnt4cglcWhp9/Uv2vTif1kiSSzuWY/Gyp953iL1X7JMDTBtpAo5W0Bo=
+	// true
 }
 
 func Example_decrypt() {
-	// Content to decrypt must be base64 encoded
+	// Content to decrypt must be base64 encoded, this one uses the legacy
+	// format relying on the nonce stored with the key
 	b64content := "EetswXTplHOz9LXemnt4cglcWhp9/Uv2vTif1kiSSzuWY/Gyp953iL1X7JMDTBtpAo5W0Bo="
 
 	// Sample AES-256 GCM key and nonce, use output from newKey()
-	key := "s928HkkJKVCGO1q1aIFq1iWG3ZDh6LB7utsZ1mRqjKg="
-	nonce := "cWcmxHPcuG0O0hY3"
+	key := Key{Key: "s928HkkJKVCGO1q1aIFq1iWG3ZDh6LB7utsZ1mRqjKg=", Nonce: "cWcmxHPcuG0O0hY3"}
 
-	result, err := decrypt(key, nonce, b64content)
+	result, err := decrypt(key, b64content)
 	if err != nil {
 		log.Fatalf("decrypt failed : %v", err)
 	}
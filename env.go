@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var runFiles []string
+var runPrefix string
+var runSeparator string
+
+// runCmd runs a command with the decrypted values as environment variables.
+var runCmd = &cobra.Command{
+	Use:   "run -f FILE... [--prefix PREFIX] [--separator SEP] -- COMMAND [ARG]...",
+	Short: "run a command with decrypted values as environment variables",
+	Long: `This command decrypts the given yaml files in memory and runs the
+	command with their values as environment variables. Variables are named
+	after the path of each value, uppercased and joined by the separator, as in
+	PREFIX_DB_PASSWORD for db.password.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		code, err := runWithEnv(runFiles, runPrefix, runSeparator, args)
+		if err != nil {
+			log.Fatalf("run failed : %v", err)
+		}
+		log.Exit(code)
+	},
+}
+
+// envInvalid matches the characters not allowed in variable names.
+var envInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// runWithEnv runs the command in args with the values of files set in its
+// environment, returning its exit status.
+func runWithEnv(files []string, prefix, separator string, args []string) (int, error) {
+	if len(files) == 0 {
+		return 1, fmt.Errorf("no file given")
+	}
+	for _, f := range files {
+		env, err := fileEnv(f, prefix, separator)
+		if err != nil {
+			return 1, err
+		}
+		for k, v := range env {
+			os.Setenv(k, v)
+		}
+	}
+	return runCommand(args[0], args[1:], nil)
+}
+
+// fileEnv returns the values of the yaml file as environment variables,
+// decrypting it if needed.
+func fileEnv(file, prefix, separator string) (map[string]string, error) {
+	plain, _, err := readSecret(file)
+	if err != nil {
+		return nil, err
+	}
+	if plain == nil {
+		return nil, fmt.Errorf("%v does not exist", file)
+	}
+	values := yaml.MapSlice{}
+	if err := yaml.Unmarshal(plain, &values); err != nil {
+		return nil, fmt.Errorf("invalid yaml in %v : %v", file, err)
+	}
+	env := map[string]string{}
+	var path []string
+	if prefix != "" {
+		path = []string{prefix}
+	}
+	flattenEnv(values, path, separator, env)
+	return env, nil
+}
+
+// flattenEnv adds each scalar in v to env, named after its path.
+func flattenEnv(v interface{}, path []string, separator string, env map[string]string) {
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		for _, item := range t {
+			flattenEnv(item.Value, append(path[:len(path):len(path)], fmt.Sprintf("%v", item.Key)), separator, env)
+		}
+	case []interface{}:
+		for i, item := range t {
+			flattenEnv(item, append(path[:len(path):len(path)], fmt.Sprintf("%d", i)), separator, env)
+		}
+	case nil:
+		env[envName(path, separator)] = ""
+	default:
+		env[envName(path, separator)] = fmt.Sprintf("%v", t)
+	}
+}
+
+// envName returns the variable name for the given path.
+func envName(path []string, separator string) string {
+	return strings.ToUpper(envInvalid.ReplaceAllString(strings.Join(path, separator), "_"))
+}
+
+func init() {
+	runCmd.Flags().StringSliceVarP(&runFiles, "filename", "f", nil, "yaml file holding the values, can be repeated")
+	runCmd.Flags().StringVarP(&runPrefix, "prefix", "", "", "prefix of the variable names")
+	runCmd.Flags().StringVarP(&runSeparator, "separator", "", "_", "separator of the keys in variable names")
+	runCmd.Flags().SetInterspersed(false)
+	RootCmd.AddCommand(runCmd)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileEnv(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "secrets.yaml")
+	content := "db:\n  password: hunter2\n  port: 5432\nhosts:\n- a.example.com\n- b.example.com\napi-key: abc\nempty:\n"
+	ioutil.WriteFile(f, []byte(content), 0600)
+	if err := encryptFile(f); err != nil {
+		t.Fatalf("failed to encrypt file :: %v", err)
+	}
+
+	tests := []struct {
+		prefix    string
+		separator string
+		expected  map[string]string
+	}{
+		{"", "_", map[string]string{
+			"DB_PASSWORD": "hunter2", "DB_PORT": "5432", "HOSTS_0": "a.example.com",
+			"HOSTS_1": "b.example.com", "API_KEY": "abc", "EMPTY": "",
+		}},
+		{"app", "__", map[string]string{
+			"APP__DB__PASSWORD": "hunter2", "APP__DB__PORT": "5432", "APP__HOSTS__0": "a.example.com",
+			"APP__HOSTS__1": "b.example.com", "APP__API_KEY": "abc", "APP__EMPTY": "",
+		}},
+	}
+	for _, test := range tests {
+		env, err := fileEnv(f, test.prefix, test.separator)
+		if err != nil {
+			t.Fatalf("failed to get env :: %v", err)
+		}
+		if len(env) != len(test.expected) {
+			t.Errorf("expected: %v :: result: %v", test.expected, env)
+		}
+		for k, v := range test.expected {
+			if env[k] != v {
+				t.Errorf("expected: %v=%v :: result: %v=%v", k, v, k, env[k])
+			}
+		}
+	}
+
+	code, err := runWithEnv([]string{f}, "", "_", []string{"sh", "-c", `test "$DB_PASSWORD" = hunter2`})
+	if err != nil || code != 0 {
+		t.Errorf("expected the variables to be set in the command :: result: %v %v", code, err)
+	}
+	for k := range tests[0].expected {
+		os.Unsetenv(k)
+	}
+}
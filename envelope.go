@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	// envelopeMagic prefixes every encrypted payload using the envelope format.
+	envelopeMagic = "HBS\x00"
+	// envelopeV1 carries a per-message random nonce followed by the ciphertext.
+	envelopeV1 byte = 1
+	// envelopeV2 adds the id of the key used, before the nonce.
+	envelopeV2 byte = 2
+	// envelopeV3 adds the additional authenticated data, after the key id.
+	envelopeV3 byte = 3
+
+	nonceSize = 12
+	// keySize is the size of the AES-256 keys.
+	keySize = 32
+)
+
+// envelope is the versioned container for encrypted content. Payloads
+// without the magic header are considered legacy, and rely on the nonce
+// stored alongside the key.
+type envelope struct {
+	Version    byte
+	KeyID      string
+	AAD        []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// marshal returns the binary representation of the envelope.
+func (e envelope) marshal() []byte {
+	var b bytes.Buffer
+	b.WriteString(envelopeMagic)
+	b.WriteByte(e.Version)
+	if e.Version >= envelopeV2 {
+		b.WriteByte(byte(len(e.KeyID)))
+		b.WriteString(e.KeyID)
+	}
+	if e.Version >= envelopeV3 {
+		binary.Write(&b, binary.BigEndian, uint16(len(e.AAD)))
+		b.Write(e.AAD)
+	}
+	b.Write(e.Nonce)
+	b.Write(e.Ciphertext)
+	return b.Bytes()
+}
+
+// isEnvelope checks if data starts with the envelope magic header.
+func isEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(envelopeMagic))
+}
+
+// parseEnvelope decodes data previously produced by marshal.
+func parseEnvelope(data []byte) (envelope, error) {
+	if !isEnvelope(data) {
+		return envelope{}, fmt.Errorf("missing envelope header")
+	}
+	data = data[len(envelopeMagic):]
+	if len(data) < 1 {
+		return envelope{}, fmt.Errorf("missing envelope version")
+	}
+	e := envelope{Version: data[0]}
+	data = data[1:]
+	if e.Version < envelopeV1 || e.Version > envelopeV3 {
+		return envelope{}, fmt.Errorf("unsupported envelope version %d", e.Version)
+	}
+	if e.Version >= envelopeV2 {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return envelope{}, fmt.Errorf("truncated envelope")
+		}
+		e.KeyID, data = string(data[1:1+int(data[0])]), data[1+int(data[0]):]
+	}
+	if e.Version >= envelopeV3 {
+		if len(data) < 2 {
+			return envelope{}, fmt.Errorf("truncated envelope")
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+n {
+			return envelope{}, fmt.Errorf("truncated envelope")
+		}
+		e.AAD, data = data[2:2+n], data[2+n:]
+	}
+	if len(data) < nonceSize {
+		return envelope{}, fmt.Errorf("truncated envelope")
+	}
+	e.Nonce, e.Ciphertext = data[:nonceSize], data[nonceSize:]
+	return e, nil
+}
+
+// decodeEnvelope parses a base64 encoded envelope. It fails for legacy
+// payloads.
+func decodeEnvelope(b64payload string) (envelope, error) {
+	payload, err := base64.StdEncoding.DecodeString(b64payload)
+	if err != nil {
+		return envelope{}, err
+	}
+	return parseEnvelope(payload)
+}
+
+// envelopeKeyID returns the id of the key used to encrypt the given base64
+// encoded payload, or an empty string if it is not recorded.
+func envelopeKeyID(b64payload string) string {
+	e, _ := decodeEnvelope(b64payload)
+	return e.KeyID
+}
+
+// binding ties encrypted content to the release, file and value path it
+// belongs to. It is stored as additional authenticated data, so it can not be
+// modified.
+type binding struct {
+	Release string
+	File    string
+	Path    string
+}
+
+// marshal returns the binding as additional authenticated data.
+func (b binding) marshal() []byte {
+	if b == (binding{}) {
+		return nil
+	}
+	aad := fmt.Sprintf("release=%v\nfile=%v", b.Release, b.File)
+	if b.Path != "" {
+		aad = fmt.Sprintf("%v\npath=%v", aad, b.Path)
+	}
+	return []byte(aad)
+}
+
+// parseBinding decodes data previously produced by marshal.
+func parseBinding(data []byte) (binding, error) {
+	b := binding{}
+	if len(data) == 0 {
+		return b, nil
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return b, fmt.Errorf("invalid binding %q", line)
+		}
+		switch kv[0] {
+		case "release":
+			b.Release = kv[1]
+		case "file":
+			b.File = kv[1]
+		case "path":
+			b.Path = kv[1]
+		}
+	}
+	return b, nil
+}
+
+// envelopeBinding returns the binding recorded in the given base64 encoded
+// payload, if any.
+func envelopeBinding(b64payload string) binding {
+	e, _ := decodeEnvelope(b64payload)
+	b, _ := parseBinding(e.AAD)
+	return b
+}
+
+// verify checks that content bound to b is being used for the expected
+// release, file and path.
+func (b binding) verify(expected binding) error {
+	if b == (binding{}) || b == expected {
+		return nil
+	}
+	if b.Path != expected.Path {
+		return fmt.Errorf("content was encrypted for path %q of release %q file %q, not path %q",
+			b.Path, b.Release, b.File, expected.Path)
+	}
+	return fmt.Errorf("content was encrypted for release %q file %q, not release %q file %q",
+		b.Release, b.File, expected.Release, expected.File)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// execDecrypt are the files to decrypt for exec besides those found in the
+// command arguments.
+var execDecrypt []string
+
+// execCmd runs any command with the encrypted files in its arguments
+// decrypted.
+var execCmd = &cobra.Command{
+	Use:   "exec [--decrypt FILE]... -- COMMAND [ARG]...",
+	Short: "run a command, decrypting secrets",
+	Long: `This command runs any command with every encrypted file found in its
+	arguments, alone or as a flag value (--values=FILE, key=FILE,FILE), replaced
+	by a decrypted copy removed once done. Files given with --decrypt are always
+	decrypted and replaced wherever they appear in the arguments.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		code, err := execCommand(args, execDecrypt)
+		if err != nil {
+			log.Fatalf("exec failed : %v", err)
+		}
+		log.Exit(code)
+	},
+}
+
+// fileBoundaries may surround a file name within an argument, as in a
+// script or a flag value.
+const fileBoundaries = " \t\n=,'\""
+
+// argSeparators split an argument into the files it may hold.
+var argSeparators = regexp.MustCompile(`[=,]`)
+
+// execCommand runs the command in args with the encrypted files decrypted,
+// returning its exit status.
+func execCommand(args []string, files []string) (int, error) {
+	pipes := newSecretPipes()
+	cmdArgs, decryptedFiles, err := decryptArgs(args, files, pipes)
+	defer removeTemp(decryptedFiles...)
+	if err != nil {
+		return 1, err
+	}
+	return runCommand(cmdArgs[0], cmdArgs[1:], pipes)
+}
+
+// decryptArgs replaces the encrypted files found in args, and all
+// occurrences of the given files, with decrypted copies in shared memory or
+// pipes if given. It returns the updated arguments and the list of decrypted
+// files to be removed once done.
+func decryptArgs(args []string, files []string, pipes *secretPipes) ([]string, []string, error) {
+	decryptedFiles := []string{}
+	copies := map[string]string{}
+	decryptCopy := func(fname string) (string, error) {
+		if c, ok := copies[fname]; ok {
+			return c, nil
+		}
+		if info, err := os.Stat(fname); err != nil || !info.Mode().IsRegular() {
+			return "", nil
+		}
+		var c string
+		var err error
+		if pipes != nil {
+			c, err = decryptToPipe(fname, pipes)
+		} else if c, err = decryptToTemp(fname); c != "" {
+			decryptedFiles = append(decryptedFiles, c)
+		}
+		copies[fname] = c
+		return c, err
+	}
+
+	result := append([]string{}, args...)
+	for _, f := range files {
+		c, err := decryptCopy(f)
+		if err != nil {
+			return args, decryptedFiles, err
+		}
+		if c == "" {
+			return args, decryptedFiles, fmt.Errorf("%v is not an encrypted file", f)
+		}
+		for i := range result {
+			result[i] = replaceFileArg(result[i], f, c)
+		}
+	}
+	// other files are only decrypted if recognised as encrypted by this
+	// version, legacy payloads being plain base64
+	decryptFound := func(fname string) (string, error) {
+		if !encryptedFile(fname) {
+			return "", nil
+		}
+		return decryptCopy(fname)
+	}
+	for i, arg := range result {
+		rewritten, err := rewriteArg(arg, decryptFound)
+		if err != nil {
+			return args, decryptedFiles, err
+		}
+		result[i] = rewritten
+	}
+	return result, decryptedFiles, nil
+}
+
+// encryptedFile checks if fname holds content encrypted in the envelope
+// format, or values encrypted in values mode.
+func encryptedFile(fname string) bool {
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return false
+	}
+	return hasEncryptedValues(content) || (contentMode(content) == FileMode && !isLegacy(content))
+}
+
+// rewriteArg replaces the files found in arg, either the whole argument or
+// the parts separated by '=' or ',', with the non empty result of fn.
+func rewriteArg(arg string, fn fileRewriter) (string, error) {
+	if c, err := fn(arg); c != "" || err != nil {
+		return c, err
+	}
+	rewritten := ""
+	last := 0
+	for _, loc := range append(argSeparators.FindAllStringIndex(arg, -1), []int{len(arg), len(arg)}) {
+		part := arg[last:loc[0]]
+		c, err := fn(part)
+		if err != nil {
+			return arg, err
+		}
+		if c == "" {
+			c = part
+		}
+		rewritten += c + arg[loc[0]:loc[1]]
+		last = loc[1]
+	}
+	return rewritten, nil
+}
+
+// replaceFileArg replaces the occurrences of the file f in arg with c, only
+// where f is bounded by the start or end of arg or by one of fileBoundaries,
+// so that other files containing its name are left alone.
+func replaceFileArg(arg, f, c string) string {
+	rewritten := ""
+	for {
+		i := strings.Index(arg, f)
+		if i < 0 {
+			return rewritten + arg
+		}
+		end := i + len(f)
+		if (i == 0 || strings.ContainsRune(fileBoundaries, rune(arg[i-1]))) &&
+			(end == len(arg) || strings.ContainsRune(fileBoundaries, rune(arg[end]))) {
+			rewritten += arg[:i] + c
+		} else {
+			rewritten += arg[:end]
+		}
+		arg = arg[end:]
+	}
+}
+
+// runCommand runs name with the current stdio attached and the given pipes
+// as extra files, and returns its exit status. SIGINT and SIGTERM are
+// forwarded to it by handleSignals. A command killed by a signal results in
+// 128 plus the signal number, as in shells.
+func runCommand(name string, args []string, pipes *secretPipes) (int, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if pipes != nil {
+		cmd.ExtraFiles = pipes.readers
+	}
+
+	if err := cmd.Start(); err != nil {
+		if pipes != nil {
+			pipes.close()
+		}
+		return 1, err
+	}
+	if pipes != nil {
+		pipes.start()
+	}
+	setChild(cmd.Process)
+	err := cmd.Wait()
+	setChild(nil)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func init() {
+	execCmd.Flags().StringSliceVarP(&execDecrypt, "decrypt", "", nil, "file to decrypt, replaced wherever it appears in the arguments")
+	execCmd.Flags().SetInterspersed(false)
+	RootCmd.AddCommand(execCmd)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		script   string
+		expected int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -TERM $$", 143},
+	}
+	for _, test := range tests {
+		code, err := runCommand("sh", []string{"-c", test.script}, nil)
+		if err != nil {
+			t.Fatalf("failed to run %v :: %v", test.script, err)
+		}
+		if code != test.expected {
+			t.Errorf("expected: %v :: result: %v", test.expected, code)
+		}
+	}
+	if _, err := runCommand("/nonexistent", nil, nil); err == nil {
+		t.Errorf("expected missing command to fail")
+	}
+}
+
+func TestDecryptArgs(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer useTempDir(dir)()
+
+	secrets := filepath.Join(dir, "secrets.yaml")
+	plain := filepath.Join(dir, "values.yaml")
+	ioutil.WriteFile(secrets, []byte("password: hunter2\n"), 0600)
+	ioutil.WriteFile(plain, []byte("replicas: 1\n"), 0600)
+	if err := encryptFile(secrets); err != nil {
+		t.Fatalf("failed to encrypt file :: %v", err)
+	}
+
+	args := []string{"helmfile", secrets, "--values=" + plain + "," + secrets, "--set-file", "a=" + secrets,
+		"cat " + secrets, dir}
+	result, decrypted, err := decryptArgs(args, nil, nil)
+	defer removeTemp(decrypted...)
+	if err != nil {
+		t.Fatalf("failed to decrypt args :: %v", err)
+	}
+	if len(decrypted) != 1 {
+		t.Fatalf("expected the file to be decrypted once :: result: %v", decrypted)
+	}
+	c := decrypted[0]
+	expected := []string{"helmfile", c, "--values=" + plain + "," + c, "--set-file", "a=" + c,
+		"cat " + secrets, dir}
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Errorf("expected: %v :: result: %v", expected[i], result[i])
+		}
+	}
+	content, _ := ioutil.ReadFile(c)
+	if string(content) != "password: hunter2\n" {
+		t.Errorf("expected: %v :: result: %v", "password: hunter2\n", string(content))
+	}
+
+	result, decrypted, err = decryptArgs(args, []string{secrets}, nil)
+	defer removeTemp(decrypted...)
+	if err != nil {
+		t.Fatalf("failed to decrypt args :: %v", err)
+	}
+	if result[5] != "cat "+decrypted[0] {
+		t.Errorf("expected: %v :: result: %v", "cat "+decrypted[0], result[5])
+	}
+	if _, _, err := decryptArgs(args, []string{plain}, nil); err == nil {
+		t.Errorf("expected decrypt of plain file to fail")
+	}
+
+	// plain base64 files are passed through
+	token := filepath.Join(dir, "token")
+	ioutil.WriteFile(token, []byte("dG9rZW4="), 0600)
+	args = []string{"kubectl", "create", "secret", "generic", "--from-file=token=" + token}
+	result, decrypted, err = decryptArgs(args, nil, nil)
+	defer removeTemp(decrypted...)
+	if err != nil {
+		t.Fatalf("failed to decrypt args :: %v", err)
+	}
+	if result[4] != args[4] {
+		t.Errorf("expected: %v :: result: %v", args[4], result[4])
+	}
+}
+
+func TestDecryptArgsBounded(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer useTempDir(dir)()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working dir :: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("failed to change dir :: %v", err)
+	}
+	defer os.Chdir(cwd)
+
+	ioutil.WriteFile("secrets.yaml", []byte("password: hunter2\n"), 0600)
+	if err := encryptFile("secrets.yaml"); err != nil {
+		t.Fatalf("failed to encrypt file :: %v", err)
+	}
+
+	args := []string{"sh", "-c", "kubeval secrets.yaml prod-secrets.yaml secrets.yaml.bak 'secrets.yaml'",
+		"--values=secrets.yaml,prod-secrets.yaml"}
+	result, decrypted, err := decryptArgs(args, []string{"secrets.yaml"}, nil)
+	defer removeTemp(decrypted...)
+	if err != nil {
+		t.Fatalf("failed to decrypt args :: %v", err)
+	}
+	c := decrypted[0]
+	expected := []string{"sh", "-c", "kubeval " + c + " prod-secrets.yaml secrets.yaml.bak '" + c + "'",
+		"--values=" + c + ",prod-secrets.yaml"}
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Errorf("expected: %v :: result: %v", expected[i], result[i])
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// fetchValuesCmd recovers the values of a deployed release.
+var fetchValuesCmd = &cobra.Command{
+	Use:   "fetch-values RELEASE FILE [-- HELM FLAG]...",
+	Short: "write the values of a deployed release to an encrypted file",
+	Long: `This command runs helm get values for the release and writes the result
+	to the given file, encrypted with the key of the release. Extra flags, like
+	--namespace or --revision, are passed to helm after --.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := fetchValues(args[0], args[1], args[2:]); err != nil {
+			log.Fatalf("fetch-values failed : %v", err)
+		}
+	},
+}
+
+// helmValuesHeader starts the output of helm get values without -o yaml.
+var helmValuesHeader = []byte("USER-SUPPLIED VALUES:\n")
+
+// fetchValues writes the values of release, as given by helm get values, to
+// file encrypted with the release key.
+func fetchValues(release, file string, helmArgs []string) error {
+	major, err := helmMajorVersion()
+	if err != nil {
+		return err
+	}
+	args := append([]string{"get", "values", release}, helmArgs...)
+	if major >= 3 {
+		args = append(args, "--output", "yaml")
+	}
+	helmCmd := exec.Command(helmBinary(), args...)
+	helmCmd.Stderr = os.Stderr
+	values, err := helmCmd.Output()
+	if err != nil {
+		return fmt.Errorf("helm get values failed : %v", err)
+	}
+	values = bytes.TrimPrefix(values, helmValuesHeader)
+	Release = release
+	return writeEncrypted(file, values)
+}
+
+// writeEncrypted encrypts content and writes it to file, replacing any
+// existing content while keeping its mode and binding.
+func writeEncrypted(file string, content []byte) error {
+	keys, err := fileKeyring(file)
+	if err != nil {
+		return err
+	}
+	existing, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	opts, err := encryptionOptions(file, existing)
+	if err != nil {
+		return err
+	}
+	encrypted, err := keys.encryptContent(content, file, opts)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return replaceFile(file, encrypted)
+	}
+	return ioutil.WriteFile(file, encrypted, 0600)
+}
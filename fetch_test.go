@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchValues(t *testing.T) {
+	m, restore := useMemProvider()
+	defer restore()
+	defer func() { Release = "" }()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	helm := filepath.Join(dir, "helm")
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") +
+		"\nprintf 'USER-SUPPLIED VALUES:\\npassword: hunter2\\n'\n"
+	if err := ioutil.WriteFile(helm, []byte(script), 0700); err != nil {
+		t.Fatalf("failed to write test data :: %v", err)
+	}
+	os.Setenv("HELM_BIN", helm)
+	defer os.Unsetenv("HELM_BIN")
+	origVersion := helmMajorVersion
+	helmMajorVersion = func() (int, error) { return 3, nil }
+	defer func() { helmMajorVersion = origVersion }()
+
+	f := filepath.Join(dir, "secrets.yaml")
+	if err := fetchValues("mariadb", f, []string{"--namespace", "db"}); err != nil {
+		t.Fatalf("failed to fetch values :: %v", err)
+	}
+	args, _ := ioutil.ReadFile(filepath.Join(dir, "args"))
+	expected := "get values mariadb --namespace db --output yaml"
+	if strings.TrimSpace(string(args)) != expected {
+		t.Errorf("expected: %v :: result: %v", expected, string(args))
+	}
+	keys, _ := m.List("mariadb")
+	if len(keys) != 1 {
+		t.Fatalf("expected a key for the release :: result: %+v", m.keys)
+	}
+	content, _ := ioutil.ReadFile(f)
+	if !isEncrypted(content) || envelopeKeyID(string(content)) != keys[0].ID {
+		t.Errorf("expected content encrypted with key %v :: result: %v", keys[0].ID, string(content))
+	}
+	var out bytes.Buffer
+	if err := viewFile(f, &out); err != nil {
+		t.Fatalf("failed to view file :: %v", err)
+	}
+	if out.String() != "password: hunter2\n" {
+		t.Errorf("expected: %v :: result: %v", "password: hunter2\n", out.String())
+	}
+}
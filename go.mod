@@ -8,5 +8,7 @@ require (
 	github.com/sirupsen/logrus v1.1.0
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/pflag v1.0.2 // indirect
-	gopkg.in/yaml.v2 v2.2.1 // indirect
+	github.com/xeipuuv/gojsonschema v1.2.0
+	gopkg.in/yaml.v2 v2.2.1
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// helmBoolFlags are the helm flags not taking a value, used to find the
+// positional arguments of a command.
+var helmBoolFlags = map[string]bool{
+	"--atomic": true, "--cleanup-on-fail": true, "--create-namespace": true,
+	"--debug": true, "--dependency-update": true, "--devel": true,
+	"--disable-openapi-validation": true, "--dry-run": true, "--enable-dns": true,
+	"--force": true, "--generate-name": true, "-g": true, "--help": true, "-h": true,
+	"--hide-notes": true, "--include-crds": true, "--insecure-skip-tls-verify": true,
+	"--install": true, "-i": true, "--is-upgrade": true, "--no-hooks": true,
+	"--pass-credentials": true, "--plain-http": true, "--quiet": true,
+	"--release-name": true, "--render-subchart-notes": true, "--replace": true,
+	"--reset-then-reuse-values": true, "--reset-values": true, "--reuse-values": true,
+	"--skip-crds": true, "--skip-schema-validation": true, "--skip-tests": true,
+	"--strict": true, "--take-ownership": true, "--validate": true, "--verify": true,
+	"--wait": true, "--wait-for-jobs": true, "--with-subcharts": true,
+	// helm 2 upgrade
+	"--recreate-pods": true, "--tls": true, "--tls-verify": true,
+	// helm test
+	"--cleanup": true, "--logs": true,
+	// helm diff plugin
+	"--allow-unreleased": true, "--detailed-exitcode": true, "--disable-validation": true,
+	"--include-tests": true, "--no-color": true, "--normalize-manifests": true,
+	"--show-secrets": true, "--strip-trailing-cr": true, "--suppress-secrets": true,
+	"--three-way-merge": true,
+}
+
+var helmVersionRegex = regexp.MustCompile(`v(\d+)\.`)
+
+// helmMajorVersion returns the major version of the helm client. It can be
+// replaced in tests.
+var helmMajorVersion = func() (int, error) {
+	// helm 2 requires --client to not contact tiller, helm 3 ignores it
+	out, err := exec.Command(helmBinary(), "version", "--client", "--short").Output()
+	if err != nil {
+		out, err = exec.Command(helmBinary(), "version", "--short").Output()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not get helm version : %v", err)
+	}
+	m := helmVersionRegex.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("could not parse helm version %q", strings.TrimSpace(string(out)))
+	}
+	return strconv.Atoi(string(m[1]))
+}
+
+// helmBinary returns the helm binary to run, as given by helm to plugins.
+func helmBinary() string {
+	if bin := os.Getenv("HELM_BIN"); bin != "" {
+		return bin
+	}
+	return "helm"
+}
+
+// helmReleaseName returns the release name given in the arguments of a helm
+// command, or an empty string if none is given. Helm 2 install and template
+// take it with --name, while helm 3 takes it as the first positional argument
+// of install and template, where -n is the namespace. The release is always
+// the first positional argument of upgrade, test, and of the helm diff
+// plugin subcommands.
+func helmReleaseName(cmd string, args []string, major int) string {
+	if cmd == "diff" {
+		if len(args) == 0 {
+			return ""
+		}
+		return helmReleaseName("test", args[1:], major)
+	}
+	if major < 3 && cmd != "upgrade" && cmd != "test" {
+		for i, flag := range args {
+			if strings.HasPrefix(flag, "--name=") {
+				return strings.TrimPrefix(flag, "--name=")
+			}
+			if (flag == "--name" || flag == "-n") && i < len(args)-1 {
+				return args[i+1]
+			}
+		}
+		return ""
+	}
+
+	switch cmd {
+	case "install", "upgrade", "template", "test":
+	default:
+		return ""
+	}
+	positional := []string{}
+	generated := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			positional = append(positional, args[i+1:]...)
+			i = len(args)
+		case arg == "--generate-name" || arg == "-g":
+			generated = true
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// flags given with a separate value consume the next argument
+			if !strings.Contains(arg, "=") && !helmBoolFlags[arg] && !isShortWithValue(arg) {
+				i++
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	// with a generated name, the only positional argument is the chart
+	if cmd == "upgrade" || cmd == "test" {
+		if len(positional) == 0 {
+			return ""
+		}
+		return positional[0]
+	}
+	if len(positional) < 2 || generated {
+		return ""
+	}
+	return positional[0]
+}
+
+// isShortWithValue checks for a shorthand flag with its value attached, as
+// in -nmynamespace.
+func isShortWithValue(arg string) bool {
+	return !strings.HasPrefix(arg, "--") && len(arg) > 2
+}
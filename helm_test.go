@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHelmReleaseName(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		args     string
+		major    int
+		expected string
+	}{
+		{"install", "stable/mariadb --name mariadb --namespace db -f secrets.yaml", 2, "mariadb"},
+		{"install", "stable/mariadb -n mariadb", 2, "mariadb"},
+		{"install", "stable/mariadb --name=mariadb", 2, "mariadb"},
+		{"upgrade", "mariadb stable/mariadb", 2, "mariadb"},
+		{"upgrade", "--install --recreate-pods --namespace db mariadb stable/mariadb -f secrets.yaml", 2, "mariadb"},
+		{"install", "mariadb stable/mariadb -n db -f secrets.yaml", 3, "mariadb"},
+		{"install", "-n db --values secrets.yaml --wait mariadb stable/mariadb", 3, "mariadb"},
+		{"install", "--namespace=db -ndb --atomic mariadb stable/mariadb", 3, "mariadb"},
+		{"install", "stable/mariadb --generate-name -n db", 3, ""},
+		{"upgrade", "--install -n db mariadb stable/mariadb", 3, "mariadb"},
+		{"template", "stable/mariadb -n db", 3, ""},
+		{"template", "mariadb stable/mariadb --set a=b", 3, "mariadb"},
+		{"lint", "stable/mariadb -f secrets.yaml", 3, ""},
+		{"install", "-- mariadb stable/mariadb", 3, "mariadb"},
+		{"test", "mariadb --logs", 3, "mariadb"},
+		{"test", "--cleanup mariadb", 2, "mariadb"},
+		{"diff", "upgrade --suppress-secrets mariadb stable/mariadb -f secrets.yaml", 3, "mariadb"},
+		{"diff", "upgrade mariadb stable/mariadb --context 3", 2, "mariadb"},
+		{"diff", "rollback mariadb 2", 3, "mariadb"},
+		{"diff", "", 3, ""},
+	}
+	for _, test := range tests {
+		name := helmReleaseName(test.cmd, strings.Fields(test.args), test.major)
+		if name != test.expected {
+			t.Errorf("helm %v %v %v :: expected: %q :: result: %q",
+				test.major, test.cmd, test.args, test.expected, name)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// keysCmd groups the key management commands.
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "manage release keys",
+	Long: `This command groups the operations on the keys used to encrypt
+	the secrets of a release.`,
+}
+
+// rotateCmd represents the 'keys rotate' command.
+var rotateCmd = &cobra.Command{
+	Use:   "rotate [FILE|DIR]...",
+	Short: "rotate the release key, re-encrypting the given files",
+	Long: `This command creates a new key for the release and re-encrypts
+	the given files with it. Directories are walked recursively, and every
+	encrypted file found is re-encrypted. The previous keys are only deleted
+	once all files have been successfully rewritten.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := rotateKey(args); err != nil {
+			log.Fatalf("rotate failed : %v", err)
+		}
+	},
+}
+
+// listCmd represents the 'keys list' command.
+var listCmd = &cobra.Command{
+	Use:   "list [FILE]",
+	Short: "list the release keys",
+	Long: `This command lists the keys of the release, newest first. The
+	newest key is used for new encryptions, while the others remain
+	available to decrypt files referring to them. If a file is given, the
+	keys of its release are listed.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var keys *keyring
+		var err error
+		if len(args) > 0 {
+			keys, err = fileKeyring(args[0])
+		} else {
+			keys, err = newKeyring(releaseName(), "", "")
+		}
+		if err != nil {
+			log.Fatalf("list failed : %v", err)
+		}
+		list, err := keys.provider.List(keys.name)
+		if err != nil {
+			log.Fatalf("list failed : %v", err)
+		}
+		for _, k := range list {
+			fmt.Printf("%v\t%v\n", k.ID, k.Created.Format(time.RFC3339))
+		}
+	},
+}
+
+// rotateKey creates a new key for the release of the given paths, re-encrypts
+// them with it and retires the previous keys.
+func rotateKey(paths []string) error {
+	files, err := encryptedFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no encrypted files found")
+	}
+	keys, err := fileKeyring(files[0])
+	if err != nil {
+		return err
+	}
+	for _, f := range files[1:] {
+		k, err := fileKeyring(f)
+		if err != nil {
+			return err
+		}
+		if k != keys {
+			return fmt.Errorf("%v and %v belong to different releases", files[0], f)
+		}
+	}
+	oldKeys, err := keys.provider.List(keys.name)
+	if err != nil {
+		return fmt.Errorf("could not list keys : %v", err)
+	}
+
+	// decrypt everything first, so nothing is touched if any file is not
+	// readable with the current keys
+	contents := map[string][]byte{}
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if _, err := keys.decryptContent(content, f); err != nil {
+			return fmt.Errorf("%v : %v", f, err)
+		}
+		contents[f] = content
+	}
+
+	if _, err := keys.rotate(); err != nil {
+		return fmt.Errorf("could not create key : %v", err)
+	}
+	failed := 0
+	for _, f := range files {
+		result, err := keys.reencryptContent(contents[f], f)
+		if err == nil {
+			err = replaceFile(f, result)
+		}
+		if err != nil {
+			log.Errorf("failed to re-encrypt %v : %v", f, err)
+			failed++
+			continue
+		}
+		log.Infof("re-encrypted %v", f)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files not re-encrypted, keeping previous keys", failed)
+	}
+
+	for _, k := range oldKeys {
+		if err := keys.provider.Delete(k.ID); err != nil {
+			return fmt.Errorf("could not delete key %v : %v", k.ID, err)
+		}
+	}
+	return nil
+}
+
+// encryptedFiles expands the given paths into the list of encrypted files,
+// walking directories recursively. Explicitly given files must be encrypted.
+func encryptedFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			content, err := ioutil.ReadFile(p)
+			if err != nil {
+				return nil, err
+			}
+			if !isEncrypted(content) {
+				return nil, fmt.Errorf("%v is not encrypted", p)
+			}
+			files = append(files, p)
+			continue
+		}
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if isEncrypted(content) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// replaceFile atomically replaces the content of an existing file, keeping
+// its permissions.
+func replaceFile(path string, content []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".secrets-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func init() {
+	keysCmd.AddCommand(listCmd)
+	keysCmd.AddCommand(rotateCmd)
+	RootCmd.AddCommand(keysCmd)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateKey(t *testing.T) {
+	m, restore := useMemProvider()
+	defer restore()
+	Release = "test"
+	defer func() { Release = "" }()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	old, _ := m.Fetch("test")
+	files := map[string]string{
+		filepath.Join(dir, "a.yaml"):        "a: 1\n",
+		filepath.Join(dir, "sub", "b.yaml"): "b: 2\n",
+	}
+	for f, content := range files {
+		encrypted, err := encrypt(old, []byte(content), nil)
+		if err != nil {
+			t.Fatalf("failed to encrypt :: %v", err)
+		}
+		os.MkdirAll(filepath.Dir(f), 0700)
+		ioutil.WriteFile(f, encrypted, 0600)
+	}
+	plainFile := filepath.Join(dir, "plain.yaml")
+	ioutil.WriteFile(plainFile, []byte("c: 3\n"), 0600)
+
+	if err := rotateKey([]string{plainFile}); err == nil {
+		t.Errorf("expected rotate of a plain file to fail")
+	}
+	if err := rotateKey([]string{dir}); err != nil {
+		t.Fatalf("failed to rotate :: %v", err)
+	}
+
+	keys, _ := m.List("test")
+	if len(keys) != 1 || keys[0].ID == old.ID {
+		t.Fatalf("expected only the new key to remain :: %+v", keys)
+	}
+	for f, content := range files {
+		encrypted, _ := ioutil.ReadFile(f)
+		if id := envelopeKeyID(string(encrypted)); id != keys[0].ID {
+			t.Errorf("expected key id %v :: result: %v", keys[0].ID, id)
+		}
+		plain, err := decrypt(keys[0], string(encrypted))
+		if err != nil {
+			t.Fatalf("failed to decrypt %v with new key :: %v", f, err)
+		}
+		if !bytes.Equal(plain, []byte(content)) {
+			t.Errorf("expected: %v :: result: %v", content, string(plain))
+		}
+	}
+	if result, _ := ioutil.ReadFile(plainFile); string(result) != "c: 3\n" {
+		t.Errorf("plain file was modified :: %v", string(result))
+	}
+}
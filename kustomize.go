@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// kustomizationFiles are the names kustomize looks for in a directory.
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// kustomization holds the fields of a kustomization file referencing other
+// files or directories.
+type kustomization struct {
+	Resources             []string `yaml:"resources"`
+	Bases                 []string `yaml:"bases"`
+	Components            []string `yaml:"components"`
+	Crds                  []string `yaml:"crds"`
+	Configurations        []string `yaml:"configurations"`
+	Generators            []string `yaml:"generators"`
+	Transformers          []string `yaml:"transformers"`
+	Validators            []string `yaml:"validators"`
+	PatchesStrategicMerge []string `yaml:"patchesStrategicMerge"`
+	PatchesJSON6902       []struct {
+		Path string `yaml:"path"`
+	} `yaml:"patchesJson6902"`
+	Patches []struct {
+		Path string `yaml:"path"`
+	} `yaml:"patches"`
+	Replacements []struct {
+		Path string `yaml:"path"`
+	} `yaml:"replacements"`
+	SecretGenerator    []kustomizeGenerator `yaml:"secretGenerator"`
+	ConfigMapGenerator []kustomizeGenerator `yaml:"configMapGenerator"`
+}
+
+// kustomizeGenerator holds the files of a secret or configmap generator.
+type kustomizeGenerator struct {
+	Files []string `yaml:"files"`
+	Envs  []string `yaml:"envs"`
+	Env   string   `yaml:"env"`
+}
+
+// references returns the paths referenced by the kustomization, which may
+// also be remote urls or inline patches.
+func (k kustomization) references() []string {
+	refs := []string{}
+	for _, l := range [][]string{k.Resources, k.Bases, k.Components, k.Crds, k.Configurations,
+		k.Generators, k.Transformers, k.Validators, k.PatchesStrategicMerge} {
+		refs = append(refs, l...)
+	}
+	for _, p := range k.PatchesJSON6902 {
+		refs = append(refs, p.Path)
+	}
+	for _, p := range k.Patches {
+		refs = append(refs, p.Path)
+	}
+	for _, r := range k.Replacements {
+		refs = append(refs, r.Path)
+	}
+	for _, g := range append(k.SecretGenerator, k.ConfigMapGenerator...) {
+		for _, f := range g.Files {
+			// files may be given as key=path
+			refs = append(refs, f[strings.Index(f, "=")+1:])
+		}
+		refs = append(refs, g.Envs...)
+		refs = append(refs, g.Env)
+	}
+	return refs
+}
+
+// decryptKustomization materialises a copy of the kustomization tree in dir
+// in a new secure temp directory, decrypting the encrypted files. It returns
+// the temp directory to remove once done, and the path of the copy of dir
+// within it.
+// Trees without encrypted files are not copied, with empty paths returned.
+func decryptKustomization(dir string) (string, string, error) {
+	files := map[string]bool{}
+	if err := kustomizationTree(dir, map[string]bool{}, files); err != nil {
+		return "", "", err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	root := abs
+	for f := range files {
+		root = commonDir(root, filepath.Dir(f))
+	}
+
+	tmpRoot, err := secureTempDir()
+	if err != nil {
+		return "", "", err
+	}
+	tmpd, err := ioutil.TempDir(tmpRoot, tempName("kustomize-"))
+	if err != nil {
+		return "", "", err
+	}
+	registerTemp(tmpd)
+	found := false
+	for f := range files {
+		plain, ok, err := readSecret(f)
+		if err != nil {
+			removeTemp(tmpd)
+			return "", "", err
+		}
+		found = found || ok
+		target := filepath.Join(tmpd, strings.TrimPrefix(f, root))
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			removeTemp(tmpd)
+			return "", "", err
+		}
+		if err := writeSecret(target, plain); err != nil {
+			removeTemp(tmpd)
+			return "", "", err
+		}
+	}
+	if !found {
+		removeTemp(tmpd)
+		return "", "", nil
+	}
+	return tmpd, filepath.Join(tmpd, strings.TrimPrefix(abs, root)), nil
+}
+
+// kustomizationTree adds to files the absolute paths of the kustomization
+// file in dir and of all the local files it references, following the
+// referenced directories.
+func kustomizationTree(dir string, seen, files map[string]bool) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if seen[dir] {
+		return nil
+	}
+	seen[dir] = true
+
+	kfile := ""
+	for _, name := range kustomizationFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			kfile = filepath.Join(dir, name)
+			break
+		}
+	}
+	if kfile == "" {
+		return fmt.Errorf("no kustomization file found in %v", dir)
+	}
+	files[kfile] = true
+	content, err := ioutil.ReadFile(kfile)
+	if err != nil {
+		return err
+	}
+	k := kustomization{}
+	if err := yaml.Unmarshal(content, &k); err != nil {
+		return fmt.Errorf("invalid %v : %v", kfile, err)
+	}
+
+	for _, ref := range k.references() {
+		// remote resources and inline patches are left to kustomize
+		if ref == "" || strings.Contains(ref, "://") || strings.Contains(ref, "\n") {
+			continue
+		}
+		path := filepath.Join(dir, ref)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			if err := kustomizationTree(path, seen, files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[path] = true
+	}
+	return nil
+}
+
+// commonDir returns the closest directory holding both a and b.
+func commonDir(a, b string) string {
+	for a != filepath.Dir(a) && b != a && !strings.HasPrefix(b, a+string(filepath.Separator)) {
+		a = filepath.Dir(a)
+	}
+	return a
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecryptKustomization(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "shm")
+	os.Mkdir(tmp, 0700)
+	defer useTempDir(tmp)()
+
+	files := map[string]string{
+		"base/kustomization.yaml":    "resources:\n- deploy.yaml\n",
+		"base/deploy.yaml":           "kind: Deployment\n",
+		"base/unused.yaml":           "kind: Service\n",
+		"overlay/kustomization.yaml": "resources:\n- ../base\n- https://example.com/remote.yaml\npatchesStrategicMerge:\n- patch.yaml\nsecretGenerator:\n- name: db\n  files:\n  - password=secret.txt\n",
+		"overlay/patch.yaml":         "kind: Deployment\n",
+		"overlay/secret.txt":         "hunter2",
+	}
+	encrypted := map[string]bool{"overlay/secret.txt": true, "base/deploy.yaml": true}
+	for f, content := range files {
+		path := filepath.Join(dir, "repo", f)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write test data :: %v", err)
+		}
+		if encrypted[f] {
+			if err := encryptFile(path); err != nil {
+				t.Fatalf("failed to encrypt file :: %v", err)
+			}
+		}
+	}
+
+	overlay := filepath.Join(dir, "repo", "overlay")
+	args, decrypted, err := decryptManifests([]string{"apply", "-k", overlay}, nil)
+	if err != nil {
+		t.Fatalf("failed to decrypt kustomization :: %v", err)
+	}
+	if len(decrypted) != 1 {
+		t.Fatalf("expected the tree to be materialised :: result: %v", decrypted)
+	}
+	defer os.RemoveAll(decrypted[0])
+	if args[2] != filepath.Join(decrypted[0], "overlay") {
+		t.Errorf("expected: %v :: result: %v", filepath.Join(decrypted[0], "overlay"), args[2])
+	}
+	for f, content := range files {
+		result, err := ioutil.ReadFile(filepath.Join(decrypted[0], f))
+		if f == "base/unused.yaml" {
+			if err == nil {
+				t.Errorf("expected unreferenced file %v to be skipped", f)
+			}
+			continue
+		}
+		if err != nil || string(result) != content {
+			t.Errorf("expected: %v :: result: %v (%v)", content, string(result), err)
+		}
+	}
+
+	args, decrypted, err = decryptManifests([]string{"apply", "--kustomize=" + filepath.Join(dir, "missing")}, nil)
+	if err == nil {
+		t.Errorf("expected missing kustomization to fail :: result: %v %v", args, decrypted)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// KeyEnv holds the key payload used by the local provider.
+	KeyEnv = "HELM_SECRETS_KEY"
+	// KeyFileEnv holds the path to the key file used by the local provider.
+	KeyFileEnv = "HELM_SECRETS_KEY_FILE"
+)
+
+// localProvider serves a single key read from a file or the environment,
+// allowing offline use without OpenStack credentials. The payload has the
+// same format as the one stored in Barbican.
+type localProvider struct {
+	key Key
+}
+
+func newLocalProvider(keyFile string) (KeyProvider, error) {
+	if keyFile == "" {
+		keyFile = os.Getenv(KeyFileEnv)
+	}
+	payload := os.Getenv(KeyEnv)
+	if keyFile != "" {
+		content, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file :: %v", err)
+		}
+		payload = string(content)
+	}
+	if payload == "" {
+		return nil, fmt.Errorf("no key given, set %v or %v", KeyFileEnv, KeyEnv)
+	}
+	key, nonce, err := parseKeyPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return localProvider{key: Key{ID: keyFingerprint(key), Key: key, Nonce: nonce}}, nil
+}
+
+func (l localProvider) Fetch(name string) (Key, error) {
+	key := l.key
+	key.Name = name
+	return key, nil
+}
+
+func (l localProvider) Create(name string) (Key, error) {
+	return Key{}, fmt.Errorf("creating keys is not supported by the local provider")
+}
+
+func (l localProvider) Delete(id string) error {
+	return fmt.Errorf("deleting keys is not supported by the local provider")
+}
+
+func (l localProvider) Get(id string) (Key, error) {
+	if id != l.key.ID {
+		return Key{}, fmt.Errorf("key %v is not available locally", id)
+	}
+	return l.key, nil
+}
+
+func (l localProvider) List(name string) ([]Key, error) {
+	key, _ := l.Fetch(name)
+	return []Key{key}, nil
+}
+
+func (l localProvider) Rotate(name string) (Key, error) {
+	return Key{}, fmt.Errorf("rotating keys is not supported by the local provider")
+}
+
+// keyFingerprint returns a short identifier for the given key, which does not
+// reveal the key itself.
+func keyFingerprint(b64key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(b64key)))[:16]
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLocalProvider(t *testing.T) {
+	os.Setenv(KeyEnv, "")
+	if _, err := newLocalProvider(""); err == nil {
+		t.Errorf("expected failure without a key")
+	}
+
+	p, err := newLocalProvider("testdata/encrypt_001.yaml.key")
+	if err != nil {
+		t.Fatalf("failed to init local provider :: %v", err)
+	}
+	key, err := p.Fetch("test")
+	if err != nil {
+		t.Fatalf("failed to fetch key :: %v", err)
+	}
+	if key.Name != "test" || key.ID != keyFingerprint(key.Key) {
+		t.Errorf("unexpected key :: %+v", key)
+	}
+
+	os.Setenv(KeyEnv, key.Key+"\n"+key.Nonce)
+	defer os.Unsetenv(KeyEnv)
+	p, err = newLocalProvider("")
+	if err != nil {
+		t.Fatalf("failed to init local provider :: %v", err)
+	}
+	envKey, _ := p.Fetch("test")
+	if envKey != key {
+		t.Errorf("expected: %+v :: result: %+v", key, envKey)
+	}
+	if _, err := p.Rotate("test"); err == nil {
+		t.Errorf("expected rotate to fail for the local provider")
+	}
+
+	// the nonce must have the size used by GCM
+	os.Setenv(KeyEnv, key.Key+"\nYWJj")
+	if _, err := newLocalProvider(""); err == nil {
+		t.Errorf("expected failure with a short nonce")
+	}
+	os.Setenv(KeyEnv, "YWJj\n"+key.Nonce)
+	if _, err := newLocalProvider(""); err == nil {
+		t.Errorf("expected failure with a short key")
+	}
+}
@@ -24,6 +24,14 @@ var RootCmd = &cobra.Command{
 var Debug bool
 var Verbose bool
 var Release string
+var Provider string
+var KeyFile string
+var Bind bool
+var Mode string
+var EncryptedRegex string
+var UnencryptedRegex string
+var Pipes bool
+var AllowDiskTemp bool
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
@@ -34,6 +42,7 @@ func Execute() {
 		os.Unsetenv(v)
 	}
 	os.Setenv("OS_AUTH_URL", strings.Replace(os.Getenv("OS_AUTH_URL"), "krb/", "", 1))
+	handleSignals()
 	if err := RootCmd.Execute(); err != nil {
 		log.Fatal(err)
 		os.Exit(1)
@@ -43,6 +52,15 @@ func Execute() {
 func init() {
 	RootCmd.PersistentFlags().BoolVarP(&Debug, "debug", "", false, "enable verbose output")
 	RootCmd.PersistentFlags().StringVarP(&Release, "name", "n", "", "release name - if unspecified, the current directory name")
+	RootCmd.PersistentFlags().StringVarP(&Provider, "provider", "", "", "key provider, one of barbican or local (default barbican)")
+	RootCmd.PersistentFlags().StringVarP(&KeyFile, "key-file", "", "", "file holding the key for the local provider")
+	RootCmd.PersistentFlags().BoolVarP(&Bind, "bind", "", false, "bind encrypted content to the release and file name")
+	RootCmd.PersistentFlags().StringVarP(&Mode, "mode", "", "", "encryption mode, file for the whole file or values for each yaml value (default file)")
+	RootCmd.PersistentFlags().StringVarP(&EncryptedRegex, "encrypted-regex", "", "", "in values mode, only encrypt values of keys matching this regex")
+	RootCmd.PersistentFlags().StringVarP(&UnencryptedRegex, "unencrypted-regex", "", "", "in values mode, do not encrypt values of keys matching this regex")
+
+	RootCmd.PersistentFlags().BoolVarP(&AllowDiskTemp, "allow-disk-temp", "", false, "allow decrypted temp files on disk when no memory backed directory is found")
+	RootCmd.PersistentFlags().BoolVarP(&Pipes, "pipes", "", false, "pass decrypted files to wrapped commands through pipes instead of temp files")
 
 	log.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
 	log.SetOutput(os.Stdout)
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestExts are the file extensions kubectl reads from a directory.
+var manifestExts = map[string]bool{".json": true, ".yaml": true, ".yml": true}
+
+// decryptManifests replaces the encrypted manifests given to kubectl in args
+// with decrypted copies in shared memory, or pipes if given. Directories are
+// mirrored, walking subdirectories if -R is given, glob patterns are expanded
+// and kustomization trees given with -k are materialised. It returns the
+// updated arguments and the files and directories to remove once done.
+func decryptManifests(args []string, pipes *secretPipes) ([]string, []string, error) {
+	m := &manifestDecrypter{recursive: recursiveFlag(args), pipes: pipes}
+	kubectlArgs, err := rewriteFileArgs(args, m.manifest)
+	if err != nil {
+		return args, m.decrypted, err
+	}
+	kubectlArgs, err = rewriteFlagArgs(kubectlArgs, kustomizeFlags, m.kustomization)
+	if err != nil {
+		return args, m.decrypted, err
+	}
+	return kubectlArgs, m.decrypted, nil
+}
+
+// manifestDecrypter decrypts the manifests given to a kubectl command.
+type manifestDecrypter struct {
+	recursive bool
+	// pipes, if set, receive the decrypted manifests instead of temp files
+	pipes *secretPipes
+	// decrypted are the temp files and directories to remove once done
+	decrypted []string
+}
+
+// manifest returns the path to use in place of the manifest file, directory
+// or glob fname. With pipes, directories are expanded to the list of their
+// manifests.
+func (m *manifestDecrypter) manifest(fname string) (string, error) {
+	if fname == "-" || strings.Contains(fname, "://") {
+		return fname, nil
+	}
+	info, err := os.Stat(fname)
+	if os.IsNotExist(err) && strings.ContainsAny(fname, "*?[") {
+		matches, err := filepath.Glob(fname)
+		if err != nil || len(matches) == 0 {
+			return fname, err
+		}
+		return m.list(fname, matches)
+	}
+	if err == nil && info.IsDir() && m.pipes != nil {
+		files, err := listManifests(fname, m.recursive)
+		if err != nil || len(files) == 0 {
+			return fname, err
+		}
+		return m.list(fname, files)
+	}
+	if err == nil && info.IsDir() {
+		tmpd, err := mirrorManifests(fname, m.recursive)
+		if tmpd == "" {
+			return fname, err
+		}
+		m.decrypted = append(m.decrypted, tmpd)
+		return tmpd, err
+	}
+	if m.pipes != nil {
+		p, err := decryptToPipe(fname, m.pipes)
+		if p == "" {
+			return fname, err
+		}
+		return p, err
+	}
+	tmpf, err := decryptToTemp(fname)
+	if tmpf == "" {
+		return fname, err
+	}
+	m.decrypted = append(m.decrypted, tmpf)
+	return tmpf, err
+}
+
+// list returns the comma separated paths to use in place of the manifests
+// fname expands to.
+func (m *manifestDecrypter) list(fname string, files []string) (string, error) {
+	result := make([]string, len(files))
+	for i, f := range files {
+		var err error
+		if result[i], err = m.manifest(f); err != nil {
+			return fname, err
+		}
+	}
+	return strings.Join(result, ","), nil
+}
+
+// kustomization returns the path to use in place of the kustomization
+// directory dir. Kustomize only reads files, so pipes cannot be used.
+func (m *manifestDecrypter) kustomization(dir string) (string, error) {
+	if m.pipes != nil {
+		return dir, fmt.Errorf("kustomizations cannot be decrypted to pipes")
+	}
+	tmpd, target, err := decryptKustomization(dir)
+	if tmpd == "" {
+		return dir, err
+	}
+	m.decrypted = append(m.decrypted, tmpd)
+	return target, err
+}
+
+// listManifests returns the manifests kubectl reads from dir.
+func listManifests(dir string, recursive bool) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if manifestExts[filepath.Ext(path)] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// mirrorManifests copies the manifests in dir to a new secure temp directory,
+// decrypting the encrypted ones, and returns its path. Subdirectories are
+// only mirrored if recursive is set. Directories without encrypted manifests
+// are not mirrored, with an empty path returned.
+func mirrorManifests(dir string, recursive bool) (string, error) {
+	files, err := listManifests(dir, recursive)
+	if err != nil {
+		return "", err
+	}
+	root, err := secureTempDir()
+	if err != nil {
+		return "", err
+	}
+	tmpd, err := ioutil.TempDir(root, tempName("manifests-"))
+	if err != nil {
+		return "", err
+	}
+	registerTemp(tmpd)
+	found := false
+	for _, f := range files {
+		plain, ok, err := readSecret(f)
+		if err == nil {
+			err = writeMirror(tmpd, dir, f, plain)
+		}
+		if err != nil {
+			removeTemp(tmpd)
+			return "", err
+		}
+		found = found || ok
+	}
+	if !found {
+		removeTemp(tmpd)
+		return "", nil
+	}
+	return tmpd, nil
+}
+
+// writeMirror writes plain to the path of file relative to dir within tmpd.
+func writeMirror(tmpd, dir, file string, plain []byte) error {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(tmpd, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	return writeSecret(target, plain)
+}
+
+// recursiveFlag checks if kubectl is asked to walk directories recursively,
+// with -R or --recursive.
+func recursiveFlag(args []string) bool {
+	recursive := false
+	for _, arg := range args {
+		switch {
+		case arg == "--":
+			return recursive
+		case arg == "--recursive" || arg == "--recursive=true":
+			recursive = true
+		case arg == "--recursive=false":
+			recursive = false
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+			// the R shorthand may be combined with others, as in -Rf dir
+			for _, c := range arg[1:] {
+				if c == 'R' {
+					recursive = true
+				}
+				if !strings.ContainsRune(boolShorthands, c) {
+					break
+				}
+			}
+		}
+	}
+	return recursive
+}
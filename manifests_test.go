@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptManifests(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "shm")
+	os.Mkdir(tmp, 0700)
+	defer useTempDir(tmp)()
+
+	manifests := filepath.Join(dir, "manifests")
+	files := map[string]string{
+		"secret.yaml":     "kind: Secret\n",
+		"plain.yaml":      "kind: ConfigMap\n",
+		"sub/secret.yaml": "kind: Secret\n",
+		"README.md":       "docs\n",
+	}
+	for f, content := range files {
+		path := filepath.Join(manifests, f)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write test data :: %v", err)
+		}
+		if strings.HasSuffix(f, "secret.yaml") {
+			if err := encryptFile(path); err != nil {
+				t.Fatalf("failed to encrypt file :: %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"-f", manifests}, []string{"secret.yaml", "plain.yaml"}},
+		{[]string{"-R", "-f", manifests}, []string{"secret.yaml", "plain.yaml", "sub/secret.yaml"}},
+		{[]string{"-Rf", manifests}, []string{"secret.yaml", "plain.yaml", "sub/secret.yaml"}},
+		{[]string{"--recursive=false", "-f", manifests}, []string{"secret.yaml", "plain.yaml"}},
+	}
+	for _, test := range tests {
+		args, decrypted, err := decryptManifests(test.args, nil)
+		if err != nil {
+			t.Fatalf("failed to decrypt manifests %v :: %v", test.args, err)
+		}
+		if len(decrypted) != 1 || args[len(args)-1] != decrypted[0] {
+			t.Fatalf("expected the directory to be mirrored :: result: %v %v", args, decrypted)
+		}
+		count := 0
+		filepath.Walk(decrypted[0], func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				count++
+			}
+			return err
+		})
+		if count != len(test.expected) {
+			t.Errorf("expected %v files :: result: %v", len(test.expected), count)
+		}
+		for _, f := range test.expected {
+			content, err := ioutil.ReadFile(filepath.Join(decrypted[0], f))
+			if err != nil || string(content) != files[f] {
+				t.Errorf("expected: %v :: result: %v (%v)", files[f], string(content), err)
+			}
+		}
+		os.RemoveAll(decrypted[0])
+	}
+
+	args, decrypted, err := decryptManifests([]string{"-f", filepath.Join(manifests, "*.yaml")}, nil)
+	if err != nil {
+		t.Fatalf("failed to decrypt glob :: %v", err)
+	}
+	if len(decrypted) != 1 || !strings.Contains(args[1], filepath.Join(manifests, "plain.yaml")) ||
+		!strings.Contains(args[1], decrypted[0]) {
+		t.Errorf("expected the glob to be expanded :: result: %v %v", args, decrypted)
+	}
+	os.RemoveAll(decrypted[0])
+
+	plain := filepath.Join(dir, "plain")
+	os.Mkdir(plain, 0700)
+	ioutil.WriteFile(filepath.Join(plain, "a.yaml"), []byte("a: 1\n"), 0600)
+	args, decrypted, err = decryptManifests([]string{"-f", plain}, nil)
+	if err != nil || len(decrypted) != 0 || args[1] != plain {
+		t.Errorf("expected plain directory to be left as is :: result: %v %v %v", args, decrypted, err)
+	}
+}
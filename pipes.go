@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// PipesEnv enables passing decrypted content through pipes when set to true.
+const PipesEnv = "HELM_SECRETS_PIPES"
+
+// usePipes checks if decrypted content is to be passed to wrapped commands
+// through pipes, with the --pipes flag or the environment.
+func usePipes() bool {
+	if Pipes {
+		return true
+	}
+	enabled, _ := strconv.ParseBool(os.Getenv(PipesEnv))
+	return enabled
+}
+
+// secretPipes passes decrypted content to a wrapped command through
+// anonymous pipes, read as /dev/fd/N, so it never appears on a filesystem.
+type secretPipes struct {
+	// readers are passed to the command as its extra files, from fd 3
+	readers  []*os.File
+	writers  []*os.File
+	contents [][]byte
+}
+
+// newSecretPipes returns the pipes to use if enabled, nil otherwise.
+func newSecretPipes() *secretPipes {
+	if !usePipes() {
+		return nil
+	}
+	return &secretPipes{}
+}
+
+// add creates a pipe for plain, returning the path to read it from in the
+// wrapped command.
+func (p *secretPipes) add(plain []byte) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	p.readers = append(p.readers, r)
+	p.writers = append(p.writers, w)
+	p.contents = append(p.contents, plain)
+	// extra files start after stdin, stdout and stderr
+	return fmt.Sprintf("/dev/fd/%d", 2+len(p.readers)), nil
+}
+
+// start writes the contents once the wrapped command is started, closing
+// the read ends held by this process.
+func (p *secretPipes) start() {
+	for _, r := range p.readers {
+		r.Close()
+	}
+	for i, w := range p.writers {
+		go func(w *os.File, content []byte) {
+			w.Write(content)
+			w.Close()
+		}(w, p.contents[i])
+	}
+}
+
+// close releases the pipes of a command which could not be started.
+func (p *secretPipes) close() {
+	for _, f := range append(p.readers, p.writers...) {
+		f.Close()
+	}
+}
+
+// decryptToPipe passes the decrypted contents of fname through a new pipe,
+// returning its path. Files not existing or not encrypted are skipped, with
+// an empty path returned.
+func decryptToPipe(fname string, pipes *secretPipes) (string, error) {
+	plain, ok, err := readSecret(fname)
+	if !ok || err != nil {
+		return "", err
+	}
+	return pipes.add(plain)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecryptToPipes(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	secrets := filepath.Join(dir, "secrets.yaml")
+	plain := filepath.Join(dir, "values.yaml")
+	ioutil.WriteFile(secrets, []byte("password: hunter2\n"), 0600)
+	ioutil.WriteFile(plain, []byte("replicas: 1\n"), 0600)
+	if err := encryptFile(secrets); err != nil {
+		t.Fatalf("failed to encrypt file :: %v", err)
+	}
+
+	pipes := &secretPipes{}
+	args, decrypted, err := decryptSecrets([]string{"-f", plain, "--values=" + secrets}, pipes)
+	if err != nil {
+		t.Fatalf("failed to decrypt secrets :: %v", err)
+	}
+	if len(decrypted) != 0 {
+		t.Errorf("expected no temp files :: result: %v", decrypted)
+	}
+	expected := []string{"-f", plain, "--values=/dev/fd/3"}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Errorf("expected: %v :: result: %v", expected[i], args[i])
+		}
+	}
+
+	script := `test "$(cat /dev/fd/3)" = "password: hunter2"`
+	code, err := runCommand("sh", []string{"-c", script}, pipes)
+	if err != nil {
+		t.Fatalf("failed to run command :: %v", err)
+	}
+	if code != 0 {
+		t.Errorf("expected the decrypted content to be read from the pipe :: result: %v", code)
+	}
+
+	if _, _, err := decryptManifests([]string{"-k", dir}, &secretPipes{}); err == nil {
+		t.Errorf("expected kustomization with pipes to fail")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// postRenderCmd is a helm post-renderer decrypting Secret manifests.
+var postRenderCmd = &cobra.Command{
+	Use:   "post-render",
+	Short: "helm post-renderer decrypting encrypted Secret values",
+	Long: `This command reads the manifests rendered by helm from stdin, decrypts
+	the values of Secret data and stringData encrypted in values mode (ENC[...])
+	with the release key, and writes the result to stdout. Use it with
+	helm install --post-renderer, passing the release with --name. The
+	configuration file rules matching the file given with --file, such as a
+	secrets file of the chart, apply as well.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// stdout holds the manifests read by helm, which only shows stderr
+		log.SetOutput(os.Stderr)
+		if err := postRender(os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("post-render failed : %v", err)
+		}
+	},
+}
+
+// postRenderFile is the file whose settings from the configuration file
+// apply to the rendered manifests, none if empty.
+var postRenderFile string
+
+// docSeparator matches the lines separating yaml documents.
+var docSeparator = regexp.MustCompile(`^---(\s|$)`)
+
+// postRender copies the manifests in r to w, decrypting the encrypted Secret
+// values. Other documents are copied as they are.
+func postRender(r io.Reader, w io.Writer) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var keys *keyring
+	var doc bytes.Buffer
+	flush := func() error {
+		defer doc.Reset()
+		if !hasEncryptedValues(doc.Bytes()) {
+			_, err := w.Write(doc.Bytes())
+			return err
+		}
+		if keys == nil {
+			if keys, err = postRenderKeyring(); err != nil {
+				return err
+			}
+		}
+		decrypted, err := decryptSecretManifest(doc.Bytes(), keys)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(decrypted)
+		return err
+	}
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if docSeparator.MatchString(line) {
+			if err := flush(); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+		doc.WriteString(line)
+	}
+	return flush()
+}
+
+// postRenderKeyring returns the keyring of the release, with the settings
+// of postRenderFile if given.
+func postRenderKeyring() (*keyring, error) {
+	if postRenderFile == "" {
+		return newKeyring(releaseName(), Provider, "")
+	}
+	return fileKeyring(postRenderFile)
+}
+
+// decryptSecretManifest decrypts the encrypted data and stringData values of
+// the Secret manifest in doc. Other kinds are returned as they are.
+func decryptSecretManifest(doc []byte, keys *keyring) ([]byte, error) {
+	manifest := yaml.MapSlice{}
+	if err := yaml.Unmarshal(doc, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest : %v", err)
+	}
+	kind, name := "", ""
+	for _, item := range manifest {
+		switch item.Key {
+		case "kind":
+			kind = fmt.Sprintf("%v", item.Value)
+		case "metadata":
+			if meta, ok := item.Value.(yaml.MapSlice); ok {
+				for _, m := range meta {
+					if m.Key == "name" {
+						name = fmt.Sprintf("%v", m.Value)
+					}
+				}
+			}
+		}
+	}
+	if kind != "Secret" {
+		return doc, nil
+	}
+	for _, item := range manifest {
+		if item.Key != "data" && item.Key != "stringData" {
+			continue
+		}
+		values, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		for i, v := range values {
+			s, ok := v.Value.(string)
+			m := encValue.FindStringSubmatch(s)
+			if !ok || m == nil {
+				continue
+			}
+			plain, err := keys.open([]byte(m[1]))
+			if err != nil {
+				return nil, fmt.Errorf("secret %v %v.%v : %v", name, item.Key, v.Key, err)
+			}
+			if b := envelopeBinding(m[1]); b.Release != "" && b.Release != keys.name {
+				return nil, fmt.Errorf("secret %v %v.%v : encrypted for release %q, not %q",
+					name, item.Key, v.Key, b.Release, keys.name)
+			}
+			var value interface{}
+			if err := yaml.Unmarshal(plain, &value); err != nil {
+				value = string(plain)
+			}
+			values[i].Value = value
+		}
+	}
+	return yaml.Marshal(manifest)
+}
+
+func init() {
+	postRenderCmd.Flags().StringVarP(&postRenderFile, "file", "", "", "file whose configuration rules apply to the manifests")
+	RootCmd.AddCommand(postRenderCmd)
+}
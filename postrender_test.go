@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPostRender(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+	Release = "test"
+	defer func() { Release = "" }()
+
+	keys, err := newKeyring("test", "", "")
+	if err != nil {
+		t.Fatalf("failed to get keyring :: %v", err)
+	}
+	sealed, err := keys.encrypt([]byte("hunter2\n"), "", "", false)
+	if err != nil {
+		t.Fatalf("failed to encrypt :: %v", err)
+	}
+	other, _ := newKeyring("other", "", "")
+	bound, err := other.encrypt([]byte("hunter2\n"), "secrets.yaml", "password", true)
+	if err != nil {
+		t.Fatalf("failed to encrypt :: %v", err)
+	}
+
+	configMap := fmt.Sprintf("kind: ConfigMap\ndata:\n  password: ENC[%s]\n", sealed)
+	plain := "# Source: chart/templates/service.yaml\nkind: Service\n"
+	input := fmt.Sprintf("---\nkind: Secret\nmetadata:\n  name: db\nstringData:\n  password: ENC[%s]\n  user: admin\n"+
+		"---\n%s---\n%s", sealed, configMap, plain)
+	expected := "---\nkind: Secret\nmetadata:\n  name: db\nstringData:\n  password: hunter2\n  user: admin\n" +
+		"---\n" + configMap + "---\n" + plain
+
+	var out bytes.Buffer
+	if err := postRender(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("failed to post render :: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("expected: %v :: result: %v", expected, out.String())
+	}
+
+	input = fmt.Sprintf("kind: Secret\nmetadata:\n  name: db\ndata:\n  password: ENC[%s]\n", bound)
+	if err := postRender(strings.NewReader(input), &out); err == nil {
+		t.Errorf("expected value bound to another release to fail")
+	}
+}
+
+func TestPostRenderConfig(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working dir :: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("failed to change dir :: %v", err)
+	}
+	defer os.Chdir(cwd)
+
+	// the release comes from the rule matching the given file
+	postRenderFile = "secrets.yaml"
+	defer func() { postRenderFile = "" }()
+	config := "creation_rules:\n- path: \"secrets.yaml\"\n  release: prod-key\n"
+	ioutil.WriteFile(filepath.Join(dir, ConfigFile), []byte(config), 0600)
+	keys, _ := newKeyring("prod-key", "", "")
+	sealed, err := keys.encrypt([]byte("hunter2\n"), "", "", false)
+	if err != nil {
+		t.Fatalf("failed to encrypt :: %v", err)
+	}
+
+	input := fmt.Sprintf("kind: Secret\nmetadata:\n  name: db\nstringData:\n  password: ENC[%s]\n", sealed)
+	expected := "kind: Secret\nmetadata:\n  name: db\nstringData:\n  password: hunter2\n"
+	var out bytes.Buffer
+	if err := postRender(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("failed to post render :: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("expected: %v :: result: %v", expected, out.String())
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Key holds the material used to encrypt the secrets of a release.
+type Key struct {
+	// ID uniquely identifies the key in its provider.
+	ID string
+	// Name is the release the key belongs to.
+	Name string
+	// Key is the base64 encoded AES-256 key.
+	Key string
+	// Nonce is the base64 encoded nonce used by legacy payloads.
+	Nonce string
+	// Created is the creation time of the key.
+	Created time.Time
+}
+
+// KeyProvider gives access to the keys stored in a key management backend.
+type KeyProvider interface {
+	// Fetch returns the newest key for name, creating it if none exists.
+	Fetch(name string) (Key, error)
+	// Create stores a new key for name.
+	Create(name string) (Key, error)
+	// Delete removes the key with the given id.
+	Delete(id string) error
+	// Get returns the key with the given id.
+	Get(id string) (Key, error)
+	// List returns all keys stored for name, newest first.
+	List(name string) ([]Key, error)
+	// Rotate adds a new key for name, leaving the existing ones in place
+	// until they are explicitly deleted.
+	Rotate(name string) (Key, error)
+}
+
+// ProviderEnv selects the key provider when none is given as a flag.
+const ProviderEnv = "HELM_SECRETS_PROVIDER"
+
+// newKeyProvider returns the given key provider for region. If empty, the
+// provider is selected by the --provider flag or the environment, and the
+// region by the environment. It can be replaced in tests.
+var newKeyProvider = func(provider, region string) (KeyProvider, error) {
+	if provider == "" {
+		provider = Provider
+	}
+	if provider == "" {
+		provider = os.Getenv(ProviderEnv)
+	}
+	if provider == "" && KeyFile != "" {
+		provider = "local"
+	}
+	switch provider {
+	case "", "barbican":
+		return newBarbicanProvider(region)
+	case "local":
+		return newLocalProvider(KeyFile)
+	}
+	return nil, fmt.Errorf("unknown key provider %v", provider)
+}
+
+// keyring gives access to the keys of a single release, caching them for
+// the lifetime of a command.
+type keyring struct {
+	provider KeyProvider
+	name     string
+	current  *Key
+	keys     map[string]Key
+	listed   []Key
+}
+
+// newKeyring returns the keyring for the given release, using the given key
+// provider and region, or the defaults if empty.
+func newKeyring(name, provider, region string) (*keyring, error) {
+	p, err := newKeyProvider(provider, region)
+	if err != nil {
+		return nil, fmt.Errorf("could not init client :: %v", err)
+	}
+	return &keyring{provider: p, name: name, keys: map[string]Key{}}, nil
+}
+
+// newest returns the key used for new encryptions.
+func (k *keyring) newest() (Key, error) {
+	if k.current == nil {
+		key, err := k.provider.Fetch(k.name)
+		if err != nil {
+			return Key{}, fmt.Errorf("could not fetch key : %v", err)
+		}
+		k.current = &key
+	}
+	return *k.current, nil
+}
+
+// rotate adds a new key to the release, used for all following encryptions.
+func (k *keyring) rotate() (Key, error) {
+	key, err := k.provider.Rotate(k.name)
+	if err != nil {
+		return Key{}, err
+	}
+	k.current = &key
+	return key, nil
+}
+
+// target returns the binding expected for a value of file in this release.
+func (k *keyring) target(file, path string) binding {
+	return binding{Release: k.name, File: filepath.Base(file), Path: path}
+}
+
+// encrypt encrypts the payload found at path of file with the newest key of
+// the release. If bind is set, the result is bound to the release, file and
+// path.
+func (k *keyring) encrypt(payload []byte, file, path string, bind bool) ([]byte, error) {
+	key, err := k.newest()
+	if err != nil {
+		return nil, err
+	}
+	var aad []byte
+	if bind {
+		aad = k.target(file, path).marshal()
+	}
+	return encrypt(key, payload, aad)
+}
+
+// decrypt decrypts the payload found at path of file with the key it was
+// encrypted with, checking that bound content belongs to this release, file
+// and path.
+func (k *keyring) decrypt(content []byte, file, path string) ([]byte, error) {
+	plain, err := k.open(content)
+	if err != nil {
+		return nil, err
+	}
+	if err := envelopeBinding(string(content)).verify(k.target(file, path)); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// open decrypts content with the key it was encrypted with. Content not
+// recording its key is tried with all the keys of the release.
+func (k *keyring) open(content []byte) ([]byte, error) {
+	if id := envelopeKeyID(string(content)); id != "" {
+		key, err := k.get(id)
+		if err == nil {
+			return decrypt(key, string(content))
+		}
+		log.Debugf("could not get key %v, trying all release keys : %v", id, err)
+	}
+	keys, err := k.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found for %v", k.name)
+	}
+	for _, key := range keys {
+		var plain []byte
+		plain, err = decrypt(key, string(content))
+		if err == nil {
+			return plain, nil
+		}
+	}
+	return nil, err
+}
+
+// get returns the key with the given id, which must belong to the release
+// when the provider records it.
+func (k *keyring) get(id string) (Key, error) {
+	if key, ok := k.keys[id]; ok {
+		return key, nil
+	}
+	key, err := k.provider.Get(id)
+	if err != nil {
+		return Key{}, err
+	}
+	if key.Name != "" && key.Name != k.name {
+		return Key{}, fmt.Errorf("key %v belongs to release %v, not %v", id, key.Name, k.name)
+	}
+	k.keys[id] = key
+	return key, nil
+}
+
+func (k *keyring) list() ([]Key, error) {
+	if k.listed == nil {
+		keys, err := k.provider.List(k.name)
+		if err != nil {
+			return nil, fmt.Errorf("could not list keys : %v", err)
+		}
+		k.listed = keys
+	}
+	return k.listed, nil
+}
+
+// encryptContent encrypts the content of file using the given options.
+func (k *keyring) encryptContent(content []byte, file string, opts encryptOptions) ([]byte, error) {
+	switch opts.Mode {
+	case "", FileMode:
+		return k.encrypt(content, file, "", opts.Bind)
+	case ValuesMode:
+		return encryptValues(content, opts.encrypted, func(plain []byte, path string) ([]byte, error) {
+			return k.encrypt(plain, file, path, opts.Bind)
+		})
+	}
+	return nil, fmt.Errorf("unknown encryption mode %v", opts.Mode)
+}
+
+// reencryptContent encrypts again the content of file with the newest key,
+// keeping its mode and bindings. In values mode, only the values encrypted
+// before are encrypted again.
+func (k *keyring) reencryptContent(content []byte, file string) ([]byte, error) {
+	reseal := func(sealed []byte, path string) ([]byte, error) {
+		plain, err := k.decrypt(sealed, file, path)
+		if err != nil {
+			return nil, err
+		}
+		return k.encrypt(plain, file, path, envelopeBinding(string(sealed)) != binding{})
+	}
+	switch contentMode(content) {
+	case FileMode:
+		return reseal(content, "")
+	case ValuesMode:
+		return mapValues(content, func(value interface{}, path string, keys []string) (interface{}, error) {
+			s, ok := value.(string)
+			if !ok || !encValue.MatchString(s) {
+				return value, nil
+			}
+			sealed, err := reseal([]byte(encValue.FindStringSubmatch(s)[1]), path)
+			if err != nil {
+				return nil, fmt.Errorf("%v : %v", path, err)
+			}
+			return fmt.Sprintf("ENC[%s]", sealed), nil
+		})
+	}
+	return content, nil
+}
+
+// decryptContent decrypts the content of file, encrypted in any mode.
+// Unencrypted content is returned as is.
+func (k *keyring) decryptContent(content []byte, file string) ([]byte, error) {
+	switch contentMode(content) {
+	case FileMode:
+		return k.decrypt(content, file, "")
+	case ValuesMode:
+		return decryptValues(content, func(sealed []byte, path string) ([]byte, error) {
+			return k.decrypt(sealed, file, path)
+		})
+	}
+	return content, nil
+}
+
+// sortKeys orders keys newest first.
+func sortKeys(keys []Key) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].Created.After(keys[j].Created)
+	})
+}
+
+// keyPayload returns the stored representation of a key and nonce.
+func keyPayload(key, nonce string) string {
+	return fmt.Sprintf("%v\n%v", key, nonce)
+}
+
+// parseKeyPayload splits a stored payload into its key and nonce, checking
+// they decode to an AES-256 key and a GCM nonce.
+func parseKeyPayload(payload string) (string, string, error) {
+	parts := strings.Split(strings.TrimSpace(payload), "\n")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid key payload")
+	}
+	if key, err := base64.StdEncoding.DecodeString(parts[0]); err != nil || len(key) != keySize {
+		return "", "", fmt.Errorf("invalid key payload : key must be %d base64 encoded bytes", keySize)
+	}
+	if nonce, err := base64.StdEncoding.DecodeString(parts[1]); err != nil || len(nonce) != nonceSize {
+		return "", "", fmt.Errorf("invalid key payload : nonce must be %d base64 encoded bytes", nonceSize)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memProvider is an in memory KeyProvider for tests.
+type memProvider struct {
+	keys    []Key
+	created int
+}
+
+func (m *memProvider) Get(id string) (Key, error) {
+	for _, k := range m.keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return Key{}, fmt.Errorf("key %v not found", id)
+}
+
+func (m *memProvider) Fetch(name string) (Key, error) {
+	keys, _ := m.List(name)
+	if len(keys) == 0 {
+		return m.Create(name)
+	}
+	return keys[0], nil
+}
+
+func (m *memProvider) Create(name string) (Key, error) {
+	key, nonce, err := newKey()
+	if err != nil {
+		return Key{}, err
+	}
+	m.created++
+	k := Key{ID: fmt.Sprintf("key-%d", m.created), Name: name, Key: key, Nonce: nonce,
+		Created: time.Unix(int64(m.created), 0)}
+	m.keys = append(m.keys, k)
+	return k, nil
+}
+
+func (m *memProvider) Delete(id string) error {
+	for i, k := range m.keys {
+		if k.ID == id {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("key %v not found", id)
+}
+
+func (m *memProvider) List(name string) ([]Key, error) {
+	keys := []Key{}
+	for _, k := range m.keys {
+		if k.Name == name {
+			keys = append(keys, k)
+		}
+	}
+	sortKeys(keys)
+	return keys, nil
+}
+
+func (m *memProvider) Rotate(name string) (Key, error) {
+	return m.Create(name)
+}
+
+// useMemProvider makes all commands use a fresh memProvider until the
+// returned function is called.
+func useMemProvider() (*memProvider, func()) {
+	m := &memProvider{}
+	keyrings = map[fileSettings]*keyring{}
+	orig := newKeyProvider
+	newKeyProvider = func(string, string) (KeyProvider, error) { return m, nil }
+	return m, func() { newKeyProvider = orig }
+}
+
+// useTempDir makes decrypted files go to dir, even if disk backed, until the
+// returned function is called.
+func useTempDir(dir string) func() {
+	orig := os.Getenv(TempDirEnv)
+	os.Setenv(TempDirEnv, dir)
+	AllowDiskTemp = true
+	return func() {
+		os.Setenv(TempDirEnv, orig)
+		AllowDiskTemp = false
+	}
+}
+
+func TestEncryptDecryptFile(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content, err := ioutil.ReadFile("testdata/encrypt_001.yaml")
+	if err != nil {
+		t.Fatalf("failed to read test data :: %v", err)
+	}
+	f := filepath.Join(dir, "secrets.yaml")
+	if err := ioutil.WriteFile(f, content, 0600); err != nil {
+		t.Fatalf("failed to write test data :: %v", err)
+	}
+
+	if err := encryptFile(f); err != nil {
+		t.Fatalf("failed to encrypt file :: %v", err)
+	}
+	if err := encryptFile(f); err == nil {
+		t.Errorf("expected encrypt of encrypted file to fail")
+	}
+	var out bytes.Buffer
+	if err := viewFile(f, &out); err != nil {
+		t.Fatalf("failed to view file :: %v", err)
+	}
+	if out.String() != string(content) {
+		t.Errorf("expected: %v :: result: %v", string(content), out.String())
+	}
+
+	if err := decryptFile(f); err != nil {
+		t.Fatalf("failed to decrypt file :: %v", err)
+	}
+	result, _ := ioutil.ReadFile(f)
+	if !bytes.Equal(result, content) {
+		t.Errorf("expected: %v :: result: %v", string(content), string(result))
+	}
+	if err := decryptFile(f); err == nil {
+		t.Errorf("expected decrypt of plain file to fail")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	m, restore := useMemProvider()
+	defer restore()
+
+	keys, err := newKeyring("test", "", "")
+	if err != nil {
+		t.Fatalf("failed to init keyring :: %v", err)
+	}
+	first, err := keys.encrypt([]byte("first"), "secrets.yaml", "", false)
+	if err != nil {
+		t.Fatalf("failed to encrypt :: %v", err)
+	}
+	newest, _ := keys.rotate()
+	second, err := keys.encrypt([]byte("second"), "secrets.yaml", "", false)
+	if err != nil {
+		t.Fatalf("failed to encrypt :: %v", err)
+	}
+	if id := envelopeKeyID(string(second)); id != newest.ID {
+		t.Errorf("expected newest key %v to be used :: result: %v", newest.ID, id)
+	}
+
+	// legacy content has no key id, and is tried with all release keys
+	oldest, _ := m.Get("key-1")
+	gcm, _ := newGCM(oldest.Key)
+	nonce, _ := base64.StdEncoding.DecodeString(oldest.Nonce)
+	legacy := base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, []byte("legacy"), nil))
+
+	for content, expected := range map[string]string{
+		string(first): "first", string(second): "second", legacy: "legacy"} {
+		plain, err := keys.decrypt([]byte(content), "secrets.yaml", "")
+		if err != nil {
+			t.Fatalf("failed to decrypt %v :: %v", expected, err)
+		}
+		if string(plain) != expected {
+			t.Errorf("expected: %v :: result: %v", expected, string(plain))
+		}
+	}
+
+	// keys of another release are not used, even when recorded
+	other, _ := newKeyring("other", "", "")
+	m.Create("other")
+	if _, err := other.decrypt(second, "secrets.yaml", ""); err == nil {
+		t.Errorf("expected decrypt with the key of another release to fail")
+	}
+
+	m.Delete(oldest.ID)
+	keys, _ = newKeyring("test", "", "")
+	if _, err := keys.decrypt(first, "secrets.yaml", ""); err == nil {
+		t.Errorf("expected decrypt with a deleted key to fail")
+	}
+}
+
+func TestKeyringBinding(t *testing.T) {
+	m, restore := useMemProvider()
+	defer restore()
+
+	keys, _ := newKeyring("test", "", "")
+	bound, err := keys.encrypt([]byte("value"), "dir/secrets.yaml", "", true)
+	if err != nil {
+		t.Fatalf("failed to encrypt :: %v", err)
+	}
+	expected := binding{Release: "test", File: "secrets.yaml"}
+	if b := envelopeBinding(string(bound)); b != expected {
+		t.Errorf("expected: %+v :: result: %+v", expected, b)
+	}
+	if _, err := keys.decrypt(bound, "other/secrets.yaml", ""); err != nil {
+		t.Errorf("failed to decrypt bound content :: %v", err)
+	}
+	if _, err := keys.decrypt(bound, "values.yaml", ""); err == nil {
+		t.Errorf("expected decrypt for a different file to fail")
+	}
+
+	// same key under a different release
+	key, _ := m.Fetch("test")
+	m.keys = append(m.keys, Key{ID: "other", Name: "other", Key: key.Key, Nonce: key.Nonce})
+	other, _ := newKeyring("other", "", "")
+	if _, err := other.decrypt(bound, "secrets.yaml", ""); err == nil ||
+		!strings.Contains(err.Error(), `encrypted for release "test"`) {
+		t.Errorf("expected decrypt for a different release to fail :: %v", err)
+	}
+
+	// the recorded binding can not be modified
+	e, _ := decodeEnvelope(string(bound))
+	e.AAD = binding{Release: "other", File: "secrets.yaml"}.marshal()
+	tampered := base64.StdEncoding.EncodeToString(e.marshal())
+	if _, err := other.decrypt([]byte(tampered), "secrets.yaml", ""); err == nil {
+		t.Errorf("expected decrypt of tampered binding to fail")
+	}
+}
+
+func TestEditFileUnchanged(t *testing.T) {
+	m, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	editor := filepath.Join(dir, "editor")
+	ioutil.WriteFile(editor, []byte("#!/bin/sh\ntrue\n"), 0700)
+	origEditor := os.Getenv("VISUAL")
+	os.Setenv("VISUAL", editor)
+	defer os.Setenv("VISUAL", origEditor)
+
+	f := filepath.Join(dir, "secrets.yaml")
+	if err := editFile(f); err != nil {
+		t.Fatalf("failed to edit file :: %v", err)
+	}
+	if _, err := os.Stat(f); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be created :: result: %v", err)
+	}
+
+	ioutil.WriteFile(f, []byte("a: 1\n"), 0600)
+	if err := encryptFile(f); err != nil {
+		t.Fatalf("failed to encrypt file :: %v", err)
+	}
+	before, _ := ioutil.ReadFile(f)
+	if err := editFile(f); err != nil {
+		t.Fatalf("failed to edit file :: %v", err)
+	}
+	after, _ := ioutil.ReadFile(f)
+	if !bytes.Equal(before, after) {
+		t.Errorf("expected: %v :: result: %v", string(before), string(after))
+	}
+
+	ioutil.WriteFile(editor, []byte("#!/bin/sh\necho 'a: 2' > \"$1\"\n"), 0700)
+	if err := editFile(f); err != nil {
+		t.Fatalf("failed to edit file :: %v", err)
+	}
+	after, _ = ioutil.ReadFile(f)
+	if bytes.Equal(before, after) {
+		t.Errorf("expected the changed file to be rewritten")
+	}
+
+	// legacy files are upgraded even without changes
+	Release = "test"
+	defer func() { Release = "" }()
+	keycontent, _ := ioutil.ReadFile("testdata/encrypt_001.yaml.key")
+	key := strings.Split(string(keycontent), "\n")
+	m.keys = append(m.keys, Key{ID: "legacy", Name: "test", Key: key[0], Nonce: key[1]})
+	legacy, _ := ioutil.ReadFile("testdata/encrypt_001.yaml.enc")
+	ioutil.WriteFile(f, legacy, 0600)
+	ioutil.WriteFile(editor, []byte("#!/bin/sh\ntrue\n"), 0700)
+	if err := editFile(f); err != nil {
+		t.Fatalf("failed to edit file :: %v", err)
+	}
+	after, _ = ioutil.ReadFile(f)
+	if bytes.Equal(legacy, after) || isLegacy(after) {
+		t.Errorf("expected the legacy file to be upgraded :: result: %v", string(after))
+	}
+	var out bytes.Buffer
+	if err := viewFile(f, &out); err != nil {
+		t.Fatalf("failed to view file :: %v", err)
+	}
+	expected, _ := ioutil.ReadFile("testdata/encrypt_001.yaml")
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Errorf("expected: %v :: result: %v", string(expected), out.String())
+	}
+}
+
+func TestEditFileRules(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	editor := filepath.Join(dir, "editor")
+	ioutil.WriteFile(editor, []byte("#!/bin/sh\ntrue\n"), 0700)
+	origEditor := os.Getenv("EDITOR")
+	os.Setenv("EDITOR", editor)
+	defer os.Setenv("EDITOR", origEditor)
+
+	f := filepath.Join(dir, "secrets.yaml")
+	ioutil.WriteFile(f, []byte("user: admin\npassword: s3cr3t\n"), 0600)
+	Mode = ValuesMode
+	defer func() { Mode = "" }()
+	if err := encryptFile(f); err != nil {
+		t.Fatalf("failed to encrypt file :: %v", err)
+	}
+
+	// new rules are applied by an edit without changes
+	EncryptedRegex = "password"
+	defer func() { EncryptedRegex = "" }()
+	if err := editFile(f); err != nil {
+		t.Fatalf("failed to edit file :: %v", err)
+	}
+	after, _ := ioutil.ReadFile(f)
+	if !strings.Contains(string(after), "user: admin\n") || !strings.Contains(string(after), "password: ENC[") {
+		t.Errorf("expected only the password to be encrypted :: result: %v", string(after))
+	}
+
+	before := after
+	if err := editFile(f); err != nil {
+		t.Fatalf("failed to edit file :: %v", err)
+	}
+	after, _ = ioutil.ReadFile(f)
+	if !bytes.Equal(before, after) {
+		t.Errorf("expected: %v :: result: %v", string(before), string(after))
+	}
+}
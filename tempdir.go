@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// TempDirEnv sets the directory holding decrypted temp files.
+	TempDirEnv = "HELM_SECRETS_TMPDIR"
+	// AllowDiskTempEnv allows decrypted temp files on disk when set to true.
+	AllowDiskTempEnv = "HELM_SECRETS_ALLOW_DISK_TEMP"
+)
+
+// shmDir is the shared memory directory preferred for decrypted files.
+var shmDir = "/dev/shm"
+
+// tempDirs returns the directories which may hold decrypted files, in order
+// of preference. A directory set in the environment is the only candidate.
+func tempDirs() []string {
+	if dir := os.Getenv(TempDirEnv); dir != "" {
+		return []string{dir}
+	}
+	dirs := []string{shmDir}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	return append(dirs, privateTempDir())
+}
+
+// privateTempDir returns the directory for decrypted files of the current
+// user within the system temp directory. Its name does not start with
+// tempPrefix, so cleanup never takes it for the files of a process.
+func privateTempDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("helm-secrets.%d", os.Getuid()))
+}
+
+// allowDiskTemp checks if decrypted files may be written to a disk backed
+// filesystem, with the --allow-disk-temp flag or the environment.
+func allowDiskTemp() bool {
+	if AllowDiskTemp {
+		return true
+	}
+	allowed, _ := strconv.ParseBool(os.Getenv(AllowDiskTempEnv))
+	return allowed
+}
+
+// secureTempDir returns the directory to hold decrypted files: the first
+// memory backed one among /dev/shm, $XDG_RUNTIME_DIR and a private 0700
+// directory in the system temp directory. Disk backed directories are
+// refused unless explicitly allowed.
+func secureTempDir() (string, error) {
+	disk := ""
+	for _, dir := range tempDirs() {
+		if dir == privateTempDir() {
+			if err := ensurePrivateDir(dir); err != nil {
+				log.Debugf("skipping temp dir %v : %v", dir, err)
+				continue
+			}
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() || !writable(dir) {
+			continue
+		}
+		if isMemoryFS(dir) {
+			return dir, nil
+		}
+		if disk == "" {
+			disk = dir
+		}
+	}
+	if disk == "" {
+		return "", fmt.Errorf("no usable temp dir found, set %v", TempDirEnv)
+	}
+	if !allowDiskTemp() {
+		return "", fmt.Errorf("no memory backed temp dir found, refusing to write plaintext to %v "+
+			"(use --pipes, or allow it with --allow-disk-temp or %v=true)", disk, AllowDiskTempEnv)
+	}
+	log.Warnf("writing decrypted files to disk backed %v", disk)
+	return disk, nil
+}
+
+// ensurePrivateDir creates dir only accessible by the current user, checking
+// an existing one was not created by someone else.
+func ensurePrivateDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0700 {
+		return fmt.Errorf("%v is not a private directory", dir)
+	}
+	if !ownedByUser(info) {
+		return fmt.Errorf("%v is owned by another user", dir)
+	}
+	return nil
+}
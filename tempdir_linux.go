@@ -0,0 +1,19 @@
+package main
+
+import (
+	"syscall"
+)
+
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+// isMemoryFS checks if dir is on a memory backed filesystem.
+func isMemoryFS(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false
+	}
+	return st.Type == tmpfsMagic || st.Type == ramfsMagic
+}
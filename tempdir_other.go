@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// isMemoryFS checks if dir is on a memory backed filesystem, which is only
+// known on linux.
+func isMemoryFS(dir string) bool {
+	return false
+}
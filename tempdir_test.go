@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecureTempDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	orig := os.Getenv(TempDirEnv)
+	defer os.Setenv(TempDirEnv, orig)
+	os.Setenv(TempDirEnv, dir)
+
+	result, err := secureTempDir()
+	if isMemoryFS(dir) {
+		if err != nil || result != dir {
+			t.Errorf("expected: %v :: result: %v (%v)", dir, result, err)
+		}
+	} else if err == nil {
+		t.Errorf("expected disk backed %v to be refused :: result: %v", dir, result)
+	}
+
+	AllowDiskTemp = true
+	defer func() { AllowDiskTemp = false }()
+	if result, err := secureTempDir(); err != nil || result != dir {
+		t.Errorf("expected: %v :: result: %v (%v)", dir, result, err)
+	}
+
+	os.Setenv(TempDirEnv, filepath.Join(dir, "missing"))
+	if _, err := secureTempDir(); err == nil {
+		t.Errorf("expected missing temp dir to fail")
+	}
+}
+
+func TestEnsurePrivateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "private")
+	if err := ensurePrivateDir(private); err != nil {
+		t.Fatalf("failed to create private dir :: %v", err)
+	}
+	if err := ensurePrivateDir(private); err != nil {
+		t.Errorf("expected existing private dir to be used :: %v", err)
+	}
+	os.Chmod(private, 0755)
+	if err := ensurePrivateDir(private); err == nil {
+		t.Errorf("expected shared dir to be refused")
+	}
+	link := filepath.Join(dir, "link")
+	os.Symlink(private, link)
+	if err := ensurePrivateDir(link); err == nil {
+		t.Errorf("expected symlink to be refused")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Schema is the JSON schema validating edited values, or ChartSchema for the
+// values.schema.json of the chart holding the file. None by default.
+var Schema string
+
+const (
+	// ChartFile marks the root directory of a chart.
+	ChartFile = "Chart.yaml"
+	// ChartSchemaFile is the JSON schema of the chart values.
+	ChartSchemaFile = "values.schema.json"
+	// ChartSchema selects the schema of the chart holding the edited file.
+	ChartSchema = "chart"
+)
+
+// editErrorTitle starts the comment showing validation errors in the editor.
+const editErrorTitle = "# The edited content is invalid, fix the errors below:\n"
+
+// editErrorPrefix starts each error line of the comment.
+const editErrorPrefix = "# ERROR: "
+
+// RejectedSuffix is appended to the name of an edited file to keep the
+// invalid edits the user chose not to fix, encrypted.
+const RejectedSuffix = ".rejected"
+
+// confirm asks a yes or no question on the terminal, yes being the default.
+// Without a terminal on stdin, the answer is no. It can be replaced in tests.
+var confirm = func(question string) bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+	fmt.Fprint(os.Stderr, question)
+	return readAnswer(os.Stdin)
+}
+
+// readAnswer reads a yes or no answer from r, an empty line meaning yes.
+// Failing to read a full line, as on EOF, means no.
+func readAnswer(r io.Reader) bool {
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+// isTerminal checks if f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// validateEdit checks the edited content of file is valid yaml, and valid
+// against its schema if any. Only yaml files and files in values mode are
+// checked.
+func validateEdit(file string, opts encryptOptions, content []byte) error {
+	ext := filepath.Ext(file)
+	if ext != ".yaml" && ext != ".yml" && opts.Mode != ValuesMode {
+		return nil
+	}
+	schema, err := findSchema(file)
+	if err != nil {
+		return err
+	}
+	return validateValues(content, schema)
+}
+
+// findSchema returns the schema validating file: the --schema flag, or with
+// ChartSchema the values.schema.json next to the Chart.yaml in the closest
+// parent directory. An empty path is returned if there is none.
+func findSchema(file string) (string, error) {
+	if Schema != ChartSchema {
+		return Schema, nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ChartFile)); err == nil {
+			schema := filepath.Join(dir, ChartSchemaFile)
+			if _, err := os.Stat(schema); err != nil {
+				return "", nil
+			}
+			return schema, nil
+		}
+		if dir == filepath.Dir(dir) {
+			return "", nil
+		}
+	}
+}
+
+// validateValues checks each yaml document in content is valid, and valid
+// against the JSON schema file if given.
+func validateValues(content []byte, schema string) error {
+	var loader gojsonschema.JSONLoader
+	if schema != "" {
+		s, err := ioutil.ReadFile(schema)
+		if err != nil {
+			return err
+		}
+		loader = gojsonschema.NewBytesLoader(s)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid yaml : %v", err)
+		}
+		if loader == nil {
+			continue
+		}
+		if doc == nil {
+			doc = map[string]interface{}{}
+		}
+		result, err := gojsonschema.Validate(loader, gojsonschema.NewGoLoader(jsonValue(doc)))
+		if err != nil {
+			return fmt.Errorf("invalid schema %v : %v", schema, err)
+		}
+		if !result.Valid() {
+			errs := []string{}
+			for _, e := range result.Errors() {
+				errs = append(errs, e.String())
+			}
+			return fmt.Errorf("values do not match %v :\n%v", schema, strings.Join(errs, "\n"))
+		}
+	}
+}
+
+// jsonValue converts the yaml value v to the types encoding/json supports.
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, item := range t {
+			m[fmt.Sprintf("%v", k)] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range t {
+			t[i] = jsonValue(item)
+		}
+		return t
+	}
+	return v
+}
+
+// errorHeader returns the comment showing err at the top of the content
+// reopened in the editor.
+func errorHeader(err error) []byte {
+	var b bytes.Buffer
+	b.WriteString(editErrorTitle)
+	for _, line := range strings.Split(err.Error(), "\n") {
+		b.WriteString(editErrorPrefix + line + "\n")
+	}
+	return b.Bytes()
+}
+
+// stripErrorHeader removes the comment added by errorHeader from content.
+func stripErrorHeader(content []byte) []byte {
+	if !bytes.HasPrefix(content, []byte(editErrorTitle)) {
+		return content
+	}
+	content = content[len(editErrorTitle):]
+	for bytes.HasPrefix(content, []byte(editErrorPrefix)) {
+		i := bytes.IndexByte(content, '\n')
+		if i < 0 {
+			return nil
+		}
+		content = content[i+1:]
+	}
+	return content
+}
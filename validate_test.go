@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	schema := filepath.Join(dir, ChartSchemaFile)
+	ioutil.WriteFile(schema, []byte(`{"type": "object", "properties": {"port": {"type": "integer"}}}`), 0600)
+
+	tests := []struct {
+		content string
+		schema  string
+		valid   bool
+	}{
+		{"port: 80\n", "", true},
+		{"port: [80\n", "", false},
+		{"port: 80\n---\nname: db\n", schema, true},
+		{"port: http\n", schema, false},
+		{"", schema, true},
+	}
+	for _, test := range tests {
+		err := validateValues([]byte(test.content), test.schema)
+		if (err == nil) != test.valid {
+			t.Errorf("%q :: expected valid: %v :: result: %v", test.content, test.valid, err)
+		}
+	}
+
+	chart := filepath.Join(dir, "chart")
+	os.MkdirAll(filepath.Join(chart, "secrets"), 0700)
+	ioutil.WriteFile(filepath.Join(chart, ChartFile), []byte("name: chart\n"), 0600)
+	ioutil.WriteFile(filepath.Join(chart, ChartSchemaFile), []byte("{}"), 0600)
+	if result, err := findSchema(filepath.Join(chart, "secrets", "secrets.yaml")); err != nil || result != "" {
+		t.Errorf("expected no schema by default :: result: %v (%v)", result, err)
+	}
+	Schema = ChartSchema
+	defer func() { Schema = "" }()
+	result, err := findSchema(filepath.Join(chart, "secrets", "secrets.yaml"))
+	if err != nil || result != filepath.Join(chart, ChartSchemaFile) {
+		t.Errorf("expected: %v :: result: %v (%v)", filepath.Join(chart, ChartSchemaFile), result, err)
+	}
+}
+
+func TestErrorHeader(t *testing.T) {
+	content := []byte("# comment\nport: 80\n")
+	header := errorHeader(errors.New("invalid\nport"))
+	if !bytes.HasPrefix(header, []byte(editErrorTitle)) || !bytes.Contains(header, []byte(editErrorPrefix+"port\n")) {
+		t.Errorf("expected errors in header :: result: %v", string(header))
+	}
+	if result := stripErrorHeader(append(header, content...)); !bytes.Equal(result, content) {
+		t.Errorf("expected: %v :: result: %v", string(content), string(result))
+	}
+}
+
+func TestEditFileInvalid(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the editor writes invalid yaml first, then valid yaml once the error
+	// is shown
+	editor := filepath.Join(dir, "editor")
+	script := "#!/bin/sh\nif grep -q ERROR \"$1\"; then echo 'port: 80' > \"$1\"; else echo 'port: [80' > \"$1\"; fi\n"
+	ioutil.WriteFile(editor, []byte(script), 0700)
+	origEditor := os.Getenv("VISUAL")
+	os.Setenv("VISUAL", editor)
+	defer os.Setenv("VISUAL", origEditor)
+
+	questions := 0
+	reopen := true
+	origConfirm := confirm
+	confirm = func(question string) bool {
+		questions++
+		return reopen
+	}
+	defer func() { confirm = origConfirm }()
+
+	f := filepath.Join(dir, "secrets.yaml")
+	if err := editFile(f); err != nil {
+		t.Fatalf("failed to edit file :: %v", err)
+	}
+	if questions != 1 {
+		t.Errorf("expected the editor to be reopened once :: result: %v", questions)
+	}
+	var out bytes.Buffer
+	if err := viewFile(f, &out); err != nil {
+		t.Fatalf("failed to view file :: %v", err)
+	}
+	if out.String() != "port: 80\n" {
+		t.Errorf("expected: %v :: result: %v", "port: 80\n", out.String())
+	}
+
+	reopen = false
+	ioutil.WriteFile(editor, []byte("#!/bin/sh\necho 'port: [80' > \"$1\"\n"), 0700)
+	before, _ := ioutil.ReadFile(f)
+	if err := editFile(f); err == nil || !strings.Contains(err.Error(), "unchanged") {
+		t.Errorf("expected edit to fail :: result: %v", err)
+	}
+	after, _ := ioutil.ReadFile(f)
+	if !bytes.Equal(before, after) {
+		t.Errorf("expected file to be left unchanged")
+	}
+	out.Reset()
+	if err := viewFile(f+RejectedSuffix, &out); err != nil {
+		t.Fatalf("failed to view rejected edits :: %v", err)
+	}
+	if out.String() != "port: [80\n" {
+		t.Errorf("expected: %v :: result: %v", "port: [80\n", out.String())
+	}
+}
+
+func TestEditFileSchema(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, ChartFile), []byte("name: chart\n"), 0600)
+	schema := `{"type": "object", "required": ["image"]}`
+	ioutil.WriteFile(filepath.Join(dir, ChartSchemaFile), []byte(schema), 0600)
+
+	editor := filepath.Join(dir, "editor")
+	ioutil.WriteFile(editor, []byte("#!/bin/sh\necho 'db: {password: x}' > \"$1\"\n"), 0700)
+	origEditor := os.Getenv("VISUAL")
+	os.Setenv("VISUAL", editor)
+	defer os.Setenv("VISUAL", origEditor)
+
+	questions := 0
+	origConfirm := confirm
+	confirm = func(question string) bool {
+		questions++
+		return false
+	}
+	defer func() { confirm = origConfirm }()
+
+	// the chart schema only applies when asked for
+	f := filepath.Join(dir, "secrets.yaml")
+	if err := editFile(f); err != nil || questions != 0 {
+		t.Fatalf("failed to edit file :: %v (%v questions)", err, questions)
+	}
+
+	// valid yaml not matching the schema is saved once the user declines
+	// to fix it
+	Schema = ChartSchema
+	defer func() { Schema = "" }()
+	ioutil.WriteFile(editor, []byte("#!/bin/sh\necho 'db: {password: y}' > \"$1\"\n"), 0700)
+	if err := editFile(f); err != nil {
+		t.Fatalf("failed to edit file :: %v", err)
+	}
+	if questions != 1 {
+		t.Errorf("expected the user to be asked once :: result: %v", questions)
+	}
+	var out bytes.Buffer
+	if err := viewFile(f, &out); err != nil {
+		t.Fatalf("failed to view file :: %v", err)
+	}
+	if out.String() != "db: {password: y}\n" {
+		t.Errorf("expected: %v :: result: %v", "db: {password: y}\n", out.String())
+	}
+}
+
+func TestReadAnswer(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"\n", true},
+		{"y\n", true},
+		{"Yes\n", true},
+		{"n\n", false},
+		{"", false},
+		{"y", false},
+	}
+	for _, test := range tests {
+		if result := readAnswer(strings.NewReader(test.input)); result != test.expected {
+			t.Errorf("%q :: expected: %v :: result: %v", test.input, test.expected, result)
+		}
+	}
+
+	devnull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("failed to open %v :: %v", os.DevNull, err)
+	}
+	defer devnull.Close()
+	origStdin := os.Stdin
+	os.Stdin = devnull
+	defer func() { os.Stdin = origStdin }()
+	if confirm("reopen? ") {
+		t.Errorf("expected no without a terminal")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+const (
+	// FileMode encrypts the whole file as a single payload.
+	FileMode = "file"
+	// ValuesMode encrypts each yaml value separately, keeping keys readable.
+	ValuesMode = "values"
+)
+
+// encValue matches a value encrypted in values mode.
+var encValue = regexp.MustCompile(`^ENC\[([A-Za-z0-9+/=]+)\]$`)
+
+// encValueSearch finds values encrypted in values mode within a file.
+var encValueSearch = regexp.MustCompile(`ENC\[[A-Za-z0-9+/=]+\]`)
+
+// hasEncryptedValues checks if content holds values encrypted in values mode.
+func hasEncryptedValues(content []byte) bool {
+	return encValueSearch.Match(content)
+}
+
+// valueFunc transforms a single yaml value found at path, under the given
+// mapping keys.
+type valueFunc func(value interface{}, path string, keys []string) (interface{}, error)
+
+// encryptValues encrypts the values of the yaml documents in content with
+// seal, keeping the structure and keys in clear text. Only values for which
+// selected returns true are encrypted.
+func encryptValues(content []byte, selected func(keys []string) bool,
+	seal func(plain []byte, path string) ([]byte, error)) ([]byte, error) {
+	return mapValues(content, func(value interface{}, path string, keys []string) (interface{}, error) {
+		if s, ok := value.(string); ok && encValue.MatchString(s) {
+			return s, nil
+		}
+		if !selected(keys) {
+			return value, nil
+		}
+		plain, err := yaml.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := seal(plain, path)
+		if err != nil {
+			return nil, fmt.Errorf("%v : %v", path, err)
+		}
+		return fmt.Sprintf("ENC[%s]", sealed), nil
+	})
+}
+
+// decryptValues decrypts each value of the yaml documents in content
+// encrypted by encryptValues.
+func decryptValues(content []byte, open func(sealed []byte, path string) ([]byte, error)) ([]byte, error) {
+	return mapValues(content, func(value interface{}, path string, keys []string) (interface{}, error) {
+		s, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		m := encValue.FindStringSubmatch(s)
+		if m == nil {
+			return value, nil
+		}
+		plain, err := open([]byte(m[1]), path)
+		if err != nil {
+			return nil, fmt.Errorf("%v : %v", path, err)
+		}
+		var result interface{}
+		if err := yaml.Unmarshal(plain, &result); err != nil {
+			return nil, fmt.Errorf("%v : %v", path, err)
+		}
+		return result, nil
+	})
+}
+
+// sameSelection checks if the values encrypted in existing are those
+// selected by opts for encryption in plain, its decrypted content.
+func sameSelection(existing, plain []byte, opts encryptOptions) (bool, error) {
+	encrypted, err := valuePaths(existing, func(value interface{}, keys []string) bool {
+		s, ok := value.(string)
+		return ok && encValue.MatchString(s)
+	})
+	if err != nil {
+		return false, err
+	}
+	selected, err := valuePaths(plain, func(value interface{}, keys []string) bool {
+		return opts.encrypted(keys)
+	})
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(encrypted, selected), nil
+}
+
+// valuePaths returns the paths of the values in content for which match
+// returns true.
+func valuePaths(content []byte, match func(value interface{}, keys []string) bool) ([]string, error) {
+	paths := []string{}
+	_, err := mapValues(content, func(value interface{}, path string, keys []string) (interface{}, error) {
+		if match(value, keys) {
+			paths = append(paths, path)
+		}
+		return value, nil
+	})
+	return paths, err
+}
+
+// mapValues applies fn to every scalar value of the yaml documents in
+// content. Only the values changed by fn are rewritten, keeping comments and
+// the style of the others, while indentation is normalized to two spaces.
+func mapValues(content []byte, fn valueFunc) ([]byte, error) {
+	var out bytes.Buffer
+	dec := yaml3.NewDecoder(bytes.NewReader(content))
+	for i := 0; ; i++ {
+		var doc yaml3.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("values mode requires yaml mappings : %v", err)
+		}
+		if i > 0 {
+			out.WriteString("---\n")
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+			continue
+		}
+		if doc.Content[0].Kind != yaml3.MappingNode {
+			return nil, fmt.Errorf("values mode requires yaml mappings : line %d", doc.Content[0].Line)
+		}
+		if err := walkValues(doc.Content[0], "", nil, fn); err != nil {
+			return nil, err
+		}
+		enc := yaml3.NewEncoder(&out)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// walkValues calls fn for every scalar below node, replacing it with the
+// result if changed. Null values and aliases are left untouched.
+func walkValues(node *yaml3.Node, path string, keys []string, fn valueFunc) error {
+	switch node.Kind {
+	case yaml3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i].Value
+			p := k
+			if path != "" {
+				p = strings.Join([]string{path, k}, ".")
+			}
+			if err := walkValues(node.Content[i+1], p, append(keys[:len(keys):len(keys)], k), fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml3.SequenceNode:
+		for i, item := range node.Content {
+			if err := walkValues(item, fmt.Sprintf("%v[%d]", path, i), keys, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml3.ScalarNode:
+		return replaceScalar(node, path, keys, fn)
+	}
+	return nil
+}
+
+// replaceScalar replaces the scalar node with the result of fn for its
+// value, decoded as the yaml.v2 encoding of the plain values expects.
+// Comments of the node are kept.
+func replaceScalar(node *yaml3.Node, path string, keys []string, fn valueFunc) error {
+	raw, err := yaml3.Marshal(node)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if err := yaml.Unmarshal(raw, &value); err != nil {
+		return fmt.Errorf("%v : %v", path, err)
+	}
+	if value == nil {
+		return nil
+	}
+	result, err := fn(value, path, keys)
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(result, value) {
+		return nil
+	}
+	var n yaml3.Node
+	if err := n.Encode(result); err != nil {
+		return fmt.Errorf("%v : %v", path, err)
+	}
+	node.Kind, node.Style, node.Tag, node.Value, node.Content = n.Kind, n.Style, n.Tag, n.Value, n.Content
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const valuesContent = `database:
+  user: admin
+  password: s3cr3t
+  port: 5432
+  replicas:
+    - host: db1
+      primary: true
+    - host: db2
+      primary: false
+empty: null
+---
+other: value
+`
+
+func TestEncryptValues(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	keys, _ := newKeyring("test", "", "")
+	encrypted, err := keys.encryptContent([]byte(valuesContent), "secrets.yaml", encryptOptions{Mode: ValuesMode, Bind: true})
+	if err != nil {
+		t.Fatalf("failed to encrypt values :: %v", err)
+	}
+	if contentMode(encrypted) != ValuesMode {
+		t.Fatalf("expected values mode :: %v", string(encrypted))
+	}
+	for _, s := range []string{"database:", "password: ENC[", "- host: ENC[", "empty: null", "other: ENC["} {
+		if !strings.Contains(string(encrypted), s) {
+			t.Errorf("expected %q in encrypted content :: %v", s, string(encrypted))
+		}
+	}
+	for _, s := range []string{"admin", "s3cr3t", "5432", "db1"} {
+		if strings.Contains(string(encrypted), s) {
+			t.Errorf("unexpected %q in encrypted content :: %v", s, string(encrypted))
+		}
+	}
+	if !isBound(encrypted) {
+		t.Errorf("expected values to be bound")
+	}
+
+	plain, err := keys.decryptContent(encrypted, "secrets.yaml")
+	if err != nil {
+		t.Fatalf("failed to decrypt values :: %v", err)
+	}
+	if string(plain) != valuesContent {
+		t.Errorf("expected: %v :: result: %v", valuesContent, string(plain))
+	}
+
+	// encrypted values are bound to their path
+	doc := yaml.MapSlice{}
+	yaml.Unmarshal(encrypted, &doc)
+	db := doc[0].Value.(yaml.MapSlice)
+	db[0].Value, db[1].Value = db[1].Value, db[0].Value
+	swapped, _ := yaml.Marshal(doc)
+	if _, err := keys.decryptContent(swapped, "secrets.yaml"); err == nil ||
+		!strings.Contains(err.Error(), "database.user") {
+		t.Errorf("expected decrypt of swapped values to fail :: %v", err)
+	}
+}
+
+func TestEncryptValuesComments(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	content := `# database settings
+database:
+  # the admin user
+  user: admin # not root
+  password: 's3cr3t'
+  enabled: yes
+empty:
+`
+	keys, _ := newKeyring("test", "", "")
+	encrypted, err := keys.encryptContent([]byte(content), "secrets.yaml", encryptOptions{Mode: ValuesMode})
+	if err != nil {
+		t.Fatalf("failed to encrypt values :: %v", err)
+	}
+	for _, s := range []string{"# database settings\n", "  # the admin user\n", "user: ENC[", "] # not root\n", "\nempty:\n"} {
+		if !strings.Contains(string(encrypted), s) {
+			t.Errorf("expected %q in encrypted content :: %v", s, string(encrypted))
+		}
+	}
+	plain, err := keys.decryptContent(encrypted, "secrets.yaml")
+	if err != nil {
+		t.Fatalf("failed to decrypt values :: %v", err)
+	}
+	expected := strings.Replace(strings.Replace(content, "'s3cr3t'", "s3cr3t", 1), "yes", "true", 1)
+	if string(plain) != expected {
+		t.Errorf("expected: %v :: result: %v", expected, string(plain))
+	}
+}
+
+func TestEncryptValuesPartial(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	keys, _ := newKeyring("test", "", "")
+	encrypted, err := keys.encryptContent([]byte("a: 1\n"), "secrets.yaml", encryptOptions{Mode: ValuesMode})
+	if err != nil {
+		t.Fatalf("failed to encrypt values :: %v", err)
+	}
+	// already encrypted values are kept as they are
+	content := string(encrypted) + "b: 2\n"
+	result, err := keys.encryptContent([]byte(content), "secrets.yaml", encryptOptions{Mode: ValuesMode})
+	if err != nil {
+		t.Fatalf("failed to encrypt values :: %v", err)
+	}
+	if !strings.HasPrefix(string(result), string(encrypted)) {
+		t.Errorf("expected %v to be kept :: result: %v", string(encrypted), string(result))
+	}
+	plain, err := keys.decryptContent(result, "secrets.yaml")
+	if err != nil {
+		t.Fatalf("failed to decrypt values :: %v", err)
+	}
+	if string(plain) != "a: 1\nb: 2\n" {
+		t.Errorf("unexpected result :: %v", string(plain))
+	}
+}
+
+func TestEncryptValuesRules(t *testing.T) {
+	_, restore := useMemProvider()
+	defer restore()
+
+	content := `database:
+  user: admin
+  password: s3cr3t
+  tokens:
+    api: abc
+    web: def
+apikey: ghi
+keyring: jkl
+`
+	opts := encryptOptions{
+		Mode:    ValuesMode,
+		Include: regexp.MustCompile(`password|token|key$`),
+		Exclude: regexp.MustCompile(`^web$`),
+	}
+	keys, _ := newKeyring("test", "", "")
+	encrypted, err := keys.encryptContent([]byte(content), "secrets.yaml", opts)
+	if err != nil {
+		t.Fatalf("failed to encrypt values :: %v", err)
+	}
+	for _, s := range []string{"user: admin", "web: def", "keyring: jkl", "password: ENC[", "api: ENC[", "apikey: ENC["} {
+		if !strings.Contains(string(encrypted), s) {
+			t.Errorf("expected %q in encrypted content :: %v", s, string(encrypted))
+		}
+	}
+	plain, err := keys.decryptContent(encrypted, "secrets.yaml")
+	if err != nil {
+		t.Fatalf("failed to decrypt values :: %v", err)
+	}
+	if string(plain) != content {
+		t.Errorf("expected: %v :: result: %v", content, string(plain))
+	}
+
+	// re-encryption keeps clear text values untouched
+	keys.rotate()
+	rotated, err := keys.reencryptContent(encrypted, "secrets.yaml")
+	if err != nil {
+		t.Fatalf("failed to re-encrypt values :: %v", err)
+	}
+	if strings.Count(string(rotated), "ENC[") != 3 || !strings.Contains(string(rotated), "user: admin") {
+		t.Errorf("unexpected re-encrypted content :: %v", string(rotated))
+	}
+}
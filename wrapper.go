@@ -1,10 +1,9 @@
 package main
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/google/uuid"
@@ -22,11 +21,11 @@ var applyCmd = &cobra.Command{
 	Args:               cobra.ArbitraryArgs,
 	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		out, err := wrapKubectlCommand("apply", args)
+		code, err := wrapKubectlCommand("apply", args)
 		if err != nil {
-			log.Fatalf("%v", string(out))
+			log.Fatalf("apply failed : %v", err)
 		}
-		fmt.Printf(string(out))
+		log.Exit(code)
 	},
 }
 
@@ -40,11 +39,11 @@ var createCmd = &cobra.Command{
 	Args:               cobra.ArbitraryArgs,
 	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		out, err := wrapKubectlCommand("create", args)
+		code, err := wrapKubectlCommand("create", args)
 		if err != nil {
-			log.Fatalf("%v", string(out))
+			log.Fatalf("create failed : %v", err)
 		}
-		fmt.Printf(string(out))
+		log.Exit(code)
 	},
 }
 
@@ -58,11 +57,11 @@ var installCmd = &cobra.Command{
 	Args:               cobra.ArbitraryArgs,
 	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		out, err := wrapHelmCommand("install", args)
+		code, err := wrapHelmCommand("install", args)
 		if err != nil {
-			log.Fatalf("%v", string(out))
+			log.Fatalf("install failed : %v", err)
 		}
-		fmt.Printf(string(out))
+		log.Exit(code)
 	},
 }
 
@@ -76,11 +75,11 @@ var upgradeCmd = &cobra.Command{
 	Args:               cobra.ArbitraryArgs,
 	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		out, err := wrapHelmCommand("upgrade", args)
+		code, err := wrapHelmCommand("upgrade", args)
 		if err != nil {
-			log.Fatalf("%v", string(out))
+			log.Fatalf("upgrade failed : %v", err)
 		}
-		fmt.Printf(string(out))
+		log.Exit(code)
 	},
 }
 
@@ -94,11 +93,11 @@ var lintCmd = &cobra.Command{
 	Args:               cobra.ArbitraryArgs,
 	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		out, err := wrapHelmCommand("lint", args)
+		code, err := wrapHelmCommand("lint", args)
 		if err != nil {
-			log.Fatalf("%v", string(out))
+			log.Fatalf("lint failed : %v", err)
 		}
-		fmt.Printf(string(out))
+		log.Exit(code)
 	},
 }
 
@@ -112,102 +111,179 @@ var templateCmd = &cobra.Command{
 	Args:               cobra.ArbitraryArgs,
 	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		out, err := wrapHelmCommand("template", args)
+		code, err := wrapHelmCommand("template", args)
 		if err != nil {
-			log.Fatalf("%v", string(out))
+			log.Fatalf("template failed : %v", err)
 		}
-		fmt.Printf(string(out))
+		log.Exit(code)
 	},
 }
 
-func wrapHelmCommand(cmd string, args []string) ([]byte, error) {
-	for i, flag := range args {
-		if i == len(args)-1 {
-			// Last arg, can't possibly be --name and value remaining
-			break
+// diffCmd wraps the helm diff plugin command.
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "wrapper for helm diff, decrypting secrets",
+	Long: `This command wraps the helm diff plugin command,
+	but decrypting any encrypted values file using Barbican. Available
+	arguments are the same as for the default command.`,
+	Args:               cobra.ArbitraryArgs,
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		code, err := wrapHelmCommand("diff", args)
+		if err != nil {
+			log.Fatalf("diff failed : %v", err)
 		}
+		log.Exit(code)
+	},
+}
 
-		if flag == "--name" || flag == "-n" {
-			Release = args[i+1]
+// testCmd wraps the helm 'test' command.
+var testCmd = &cobra.Command{
+	Use:   "test",
+	Short: "wrapper for helm test, decrypting secrets",
+	Long: `This command wraps the default helm test command,
+	but decrypting any encrypted values file using Barbican. Available
+	arguments are the same as for the default command.`,
+	Args:               cobra.ArbitraryArgs,
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		code, err := wrapHelmCommand("test", args)
+		if err != nil {
+			log.Fatalf("test failed : %v", err)
 		}
+		log.Exit(code)
+	},
+}
+
+// wrapHelmCommand runs the helm command with the encrypted files in args
+// decrypted, returning its exit status.
+func wrapHelmCommand(cmd string, args []string) (int, error) {
+	args, err := applyWrapperFlags(args)
+	if err != nil {
+		return 1, err
 	}
-	helmArgs, decryptedFiles, err := decryptSecrets(args)
-	for _, f := range decryptedFiles {
-		defer os.Remove(f)
+	major, err := helmMajorVersion()
+	if err != nil {
+		return 1, err
 	}
+	wrappedRelease = helmReleaseName(cmd, args, major)
+	pipes := newSecretPipes()
+	helmArgs, decryptedFiles, err := decryptSecrets(args, pipes)
+	defer removeTemp(decryptedFiles...)
 	if err != nil {
-		return []byte{}, err
+		return 1, err
 	}
-	fullArgs := append([]string{cmd}, helmArgs...)
-	helmCmd := exec.Command("helm", fullArgs...)
-	return helmCmd.CombinedOutput()
+	return runCommand(helmBinary(), append([]string{cmd}, helmArgs...), pipes)
 }
 
-func wrapKubectlCommand(cmd string, args []string) ([]byte, error) {
-	helmArgs, decryptedFiles, err := decryptSecrets(args)
-	for _, f := range decryptedFiles {
-		defer os.Remove(f)
+// wrapKubectlCommand runs the kubectl command with the encrypted manifests
+// in args decrypted, returning its exit status.
+func wrapKubectlCommand(cmd string, args []string) (int, error) {
+	args, err := applyWrapperFlags(args)
+	if err != nil {
+		return 1, err
 	}
+	pipes := newSecretPipes()
+	kubectlArgs, decryptedFiles, err := decryptManifests(args, pipes)
+	defer removeTemp(decryptedFiles...)
 	if err != nil {
-		return []byte{}, err
+		return 1, err
 	}
-	fullArgs := append([]string{cmd}, helmArgs...)
-	helmCmd := exec.Command("kubectl", fullArgs...)
-	return helmCmd.CombinedOutput()
+	return runCommand("kubectl", append([]string{cmd}, kubectlArgs...), pipes)
 }
 
-func decryptSecrets(args []string) ([]string, []string, error) {
+// decryptSecrets replaces every encrypted file given in args with a
+// decrypted copy in shared memory, or a pipe if given, returning the updated
+// arguments and the list of decrypted files to be removed once done.
+func decryptSecrets(args []string, pipes *secretPipes) ([]string, []string, error) {
 	decryptedFiles := []string{}
-	helmArgs := args
-	for i, flag := range args {
-		if flag == "--values" || flag == "-f" || flag == "--filename" {
-			if len(helmArgs) > i+1 {
-				fname := helmArgs[i+1]
-				// Move to next arg if it does not exist
-				content, err := ioutil.ReadFile(fname)
-				if _, err := os.Stat(fname); os.IsNotExist(err) {
-					continue
-				}
-				// Check if content is b64encoded, if not move on
-				if !b64Encoded(string(content)) {
-					continue
-				}
-				// Decrypt the contents
-				client, err := newKeyManager()
-				if err != nil {
-					return helmArgs, decryptedFiles, err
-				}
-				key, nonce, err := fetchKey(client, releaseName())
-				if err != nil {
-					return helmArgs, decryptedFiles, err
-				}
-				plain, err := decrypt(key, nonce, string(content))
-				if err != nil {
-					return helmArgs, decryptedFiles, err
-				}
-				// Store decrypted contents in a shm file
-				uuid, err := uuid.NewRandom()
-				if err != nil {
-					return helmArgs, decryptedFiles, err
-				}
-				tmpf := fmt.Sprintf("/dev/shm/%v", uuid)
-				decryptedFiles = append(decryptedFiles, tmpf)
-				_, err = os.OpenFile(tmpf, os.O_RDWR|os.O_CREATE, 0600)
-				if err != nil {
-					return helmArgs, decryptedFiles, err
-				}
-				err = ioutil.WriteFile(tmpf, plain, 0644)
-				if err != nil {
-					return helmArgs, decryptedFiles, err
-				}
-				// Update args to access the decrypt shm file instead
-				helmArgs[i+1] = tmpf
+	helmArgs, err := rewriteFileArgs(args, func(fname string) (string, error) {
+		if pipes != nil {
+			p, err := decryptToPipe(fname, pipes)
+			if p == "" {
+				return fname, err
 			}
+			return p, err
+		}
+		tmpf, err := decryptToTemp(fname)
+		if tmpf != "" {
+			decryptedFiles = append(decryptedFiles, tmpf)
+			return tmpf, err
 		}
+		return fname, err
+	})
+	if err != nil {
+		return args, decryptedFiles, err
 	}
 	return helmArgs, decryptedFiles, nil
 }
 
+// decryptToTemp stores the decrypted contents of fname in a secure temp file,
+// returning its path. Files not existing or not encrypted are skipped, with
+// an empty path returned.
+func decryptToTemp(fname string) (string, error) {
+	plain, ok, err := readSecret(fname)
+	if !ok || err != nil {
+		return "", err
+	}
+	// Store decrypted contents in a secure temp file
+	uuid, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	dir, err := secureTempDir()
+	if err != nil {
+		return "", err
+	}
+	tmpf := filepath.Join(dir, tempName(uuid.String()))
+	registerTemp(tmpf)
+	if err := writeSecret(tmpf, plain); err != nil {
+		return tmpf, err
+	}
+	return tmpf, nil
+}
+
+// readSecret returns the decrypted contents of fname, and whether it was
+// encrypted at all. Files not existing are reported as not encrypted.
+func readSecret(fname string) ([]byte, bool, error) {
+	// Move to next arg if it does not exist
+	content, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	// Check if content is encrypted, if not move on
+	if !isEncrypted(content) {
+		return content, false, nil
+	}
+	// Decrypt the contents
+	keys, err := fileKeyring(fname)
+	if err != nil {
+		return nil, true, err
+	}
+	plain, err := keys.decryptContent(content, fname)
+	if err != nil {
+		return nil, true, err
+	}
+	return plain, true, nil
+}
+
+// writeSecret writes decrypted contents to a new file only readable by the
+// current user.
+func writeSecret(fname string, plain []byte) error {
+	f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(plain); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func init() {
 	if strings.Contains(os.Args[0], "kubectl-") {
 		RootCmd.AddCommand(applyCmd)
@@ -217,5 +293,8 @@ func init() {
 		RootCmd.AddCommand(upgradeCmd)
 		RootCmd.AddCommand(lintCmd)
 		RootCmd.AddCommand(templateCmd)
+		RootCmd.AddCommand(diffCmd)
+		RootCmd.AddCommand(testCmd)
+		RootCmd.AddCommand(fetchValuesCmd)
 	}
 }
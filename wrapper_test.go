@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrapHelmCommandConfig(t *testing.T) {
+	m, restore := useMemProvider()
+	defer restore()
+	defer func() { wrappedRelease = "" }()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("failed to create tmp dir :: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "tmp")
+	os.Mkdir(tmp, 0700)
+	defer useTempDir(tmp)()
+
+	config := "creation_rules:\n- release: prod-key\n  bind: true\n"
+	ioutil.WriteFile(filepath.Join(dir, ConfigFile), []byte(config), 0600)
+	f := filepath.Join(dir, "s.yaml")
+	ioutil.WriteFile(f, []byte("password: hunter2\n"), 0600)
+	if err := encryptFile(f); err != nil {
+		t.Fatalf("failed to encrypt file :: %v", err)
+	}
+
+	// helm checks it gets the decrypted values, given after -f
+	helm := filepath.Join(dir, "helm")
+	script := "#!/bin/sh\nwhile [ \"$1\" != -f ]; do shift; done\ntest \"$(cat \"$2\")\" = 'password: hunter2'\n"
+	ioutil.WriteFile(helm, []byte(script), 0700)
+	os.Setenv("HELM_BIN", helm)
+	defer os.Unsetenv("HELM_BIN")
+	origVersion := helmMajorVersion
+	helmMajorVersion = func() (int, error) { return 3, nil }
+	defer func() { helmMajorVersion = origVersion }()
+
+	code, err := wrapHelmCommand("upgrade", []string{"myrel", "chart", "-f", f})
+	if err != nil {
+		t.Fatalf("failed to wrap helm :: %v", err)
+	}
+	if code != 0 {
+		t.Errorf("expected helm to get the decrypted values :: result: %v", code)
+	}
+	if Release != "" {
+		t.Errorf("expected the release flag to be left unset :: result: %v", Release)
+	}
+	if keys, _ := m.List("myrel"); len(keys) != 0 {
+		t.Errorf("expected no key for the helm release :: result: %+v", keys)
+	}
+
+	// without a rule naming the key, the helm release is used
+	ioutil.WriteFile(filepath.Join(dir, ConfigFile), []byte("creation_rules:\n- bind: true\n"), 0600)
+	s, err := resolveSettings(f)
+	if err != nil || s.Release != "myrel" {
+		t.Errorf("expected: myrel :: result: %v (%v)", s.Release, err)
+	}
+}